@@ -139,7 +139,7 @@ func (m Metrics) Middleware(next http.Handler) http.Handler {
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(func(durationSec float64) {
 			labels := prometheus.Labels{"path": writer.FetchRoutePathTemplate(r)}
 			if statusRecorder, ok := w.(*writer.StatusRecorder); ok {
-				labels["status_code"] = strconv.Itoa(statusRecorder.StatusCode)
+				labels["status_code"] = strconv.Itoa(statusRecorder.Status())
 			}
 			m.counter.With(labels).Inc()
 			if contentLengthStr := r.Header.Get("Content-Length"); len(contentLengthStr) > 0 {
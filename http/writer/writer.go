@@ -15,7 +15,9 @@
 package writer
 
 import (
+	"context"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -24,7 +26,11 @@ import (
 // capture before the response is returned to the client.
 type StatusRecorder struct {
 	http.ResponseWriter
-	StatusCode int
+	StatusCode   int
+	BytesWritten int64
+	// OnWrite, if set, is called after every write with the cumulative number of bytes written.
+	// This allows progress of long-lived streaming responses to be observed before they complete.
+	OnWrite func(bytesWritten int64)
 }
 
 // WriteHeader implements the http ResponseWriter WriteHeader interface. This function acts as a
@@ -35,6 +41,32 @@ func (sr *StatusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
+// Write implements the http ResponseWriter Write interface. As with net/http, writing without
+// first calling WriteHeader implies a 200 OK status, which is recorded if no status code was set.
+// The number of bytes written is added to BytesWritten before OnWrite, if set, is called with the
+// cumulative byte count.
+func (sr *StatusRecorder) Write(b []byte) (int, error) {
+	if sr.StatusCode == 0 {
+		sr.StatusCode = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.BytesWritten += int64(n)
+	if sr.OnWrite != nil {
+		sr.OnWrite(sr.BytesWritten)
+	}
+	return n, err
+}
+
+// Status returns the recorded status code of the response. If no status code was recorded, such
+// as when the handler returned without writing, 200 OK is returned, matching the status net/http
+// sends for responses that are never written.
+func (sr *StatusRecorder) Status() int {
+	if sr.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return sr.StatusCode
+}
+
 // StatusRecorderMiddleware wraps the http.ResponseWriter with StatusRecorder so that downstream middlewares can
 // utilize the outcome status code after the response completes. This middleware should be attached as early as
 // possible.
@@ -45,12 +77,82 @@ func StatusRecorderMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// FetchRoutePathTemplate extracts the path template from a given request, or emptry string if none
-// could be found.
-func FetchRoutePathTemplate(r *http.Request) string {
+// ctxKey is the type used to uniquely place the route resolver within context.Context
+type ctxKey int
+
+// routeResolverKey is the value used to uniquely place the route resolver within context.Context
+const routeResolverKey ctxKey = iota
+
+// RouteResolver resolves the route path template, such as "/users/{id}", of a request. Route
+// resolvers allow routers other than gorilla mux to provide route path templates to the
+// instrumentation in this library.
+type RouteResolver interface {
+	ResolveRoute(r *http.Request) string
+}
+
+// RouteResolverFunc is an adapter which allows ordinary functions to be used as RouteResolvers
+type RouteResolverFunc func(r *http.Request) string
+
+// ResolveRoute calls f(r)
+func (f RouteResolverFunc) ResolveRoute(r *http.Request) string {
+	return f(r)
+}
+
+// MuxRouteResolver resolves the route path template from the current gorilla mux route. This is
+// the default RouteResolver used when no other resolver has been registered.
+var MuxRouteResolver RouteResolver = RouteResolverFunc(func(r *http.Request) string {
 	routePath := ""
 	if route := mux.CurrentRoute(r); route != nil {
 		routePath, _ = route.GetPathTemplate()
 	}
 	return routePath
+})
+
+// ServeMuxRouteResolver returns a RouteResolver which resolves the route from the pattern under
+// which the request's handler is registered on the given net/http ServeMux, allowing ServeMux
+// patterns to be used as span operation names. Go 1.22 style patterns, such as
+// "GET /users/{id}", resolve to their path, "/users/{id}". Older patterns match every path under a
+// prefix, so the request path is resolved instead. Requests with no matching handler resolve to
+// an empty string.
+//
+// Go 1.22 style patterns require building with Go 1.22 or later with the httpmuxgo121 GODEBUG
+// setting disabled, which is only the default for main modules declaring go 1.22 or later in their
+// go.mod. Otherwise, the ServeMux does not parse methods and wildcards in patterns, so requests
+// resolve as they would with older patterns. Set httpmuxgo121=0, such as with a //go:debug
+// directive in the main package, to enable them.
+func ServeMuxRouteResolver(serveMux *http.ServeMux) RouteResolver {
+	return RouteResolverFunc(func(r *http.Request) string {
+		_, pattern := serveMux.Handler(r)
+		if pattern == "" {
+			return ""
+		}
+		// Strip the method from patterns such as "GET /users/{id}"
+		if i := strings.Index(pattern, " "); i >= 0 {
+			pattern = strings.TrimLeft(pattern[i:], " ")
+		} else if !strings.Contains(pattern, "{") {
+			return r.URL.Path
+		}
+		return pattern
+	})
+}
+
+// RouteResolverMiddleware registers the given RouteResolver for all requests passing through the
+// middleware. This middleware should be attached to a router before any middleware which uses
+// FetchRoutePathTemplate.
+func RouteResolverMiddleware(resolver RouteResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeResolverKey, resolver)))
+		})
+	}
+}
+
+// FetchRoutePathTemplate extracts the path template from a given request, or empty string if none
+// could be found. The path template is resolved with the RouteResolver registered through
+// RouteResolverMiddleware, defaulting to MuxRouteResolver.
+func FetchRoutePathTemplate(r *http.Request) string {
+	if resolver, ok := r.Context().Value(routeResolverKey).(RouteResolver); ok {
+		return resolver.ResolveRoute(r)
+	}
+	return MuxRouteResolver.ResolveRoute(r)
 }
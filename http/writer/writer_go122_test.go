@@ -0,0 +1,50 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.22
+// +build go1.22
+
+//go:debug httpmuxgo121=0
+
+package writer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMuxRouteResolverPatterns(t *testing.T) {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	serveMux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {})
+	resolver := ServeMuxRouteResolver(serveMux)
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		expectedRoute string
+	}{
+		{"1.22 style patterns resolve to their path", "GET", "/users/123", "/users/{id}"},
+		{"patterns only match their method", "POST", "/users/123", ""},
+		{"older patterns resolve to the request path", "GET", "/static/css/app.css", "/static/css/app.css"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectedRoute, resolver.ResolveRoute(httptest.NewRequest(test.method, test.path, nil)))
+		})
+	}
+}
@@ -26,12 +26,57 @@ import (
 
 func TestWriteHeader(t *testing.T) {
 	recorder := httptest.NewRecorder()
-	sr := StatusRecorder{recorder, http.StatusNotImplemented}
+	sr := StatusRecorder{ResponseWriter: recorder, StatusCode: http.StatusNotImplemented}
 	sr.WriteHeader(http.StatusOK)
 	assert.Equal(t, sr.StatusCode, http.StatusOK)
 	assert.Equal(t, recorder.Result().StatusCode, http.StatusOK)
 }
 
+func TestWrite(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	var progress []int64
+	sr := StatusRecorder{
+		ResponseWriter: recorder,
+		StatusCode:     http.StatusOK,
+		OnWrite:        func(bytesWritten int64) { progress = append(progress, bytesWritten) },
+	}
+	for _, chunk := range []string{"first", "second", "third"} {
+		n, err := sr.Write([]byte(chunk))
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, int64(16), sr.BytesWritten)
+	assert.Equal(t, []int64{5, 11, 16}, progress)
+	assert.Equal(t, "firstsecondthird", recorder.Body.String())
+
+	// The callback is optional
+	sr.OnWrite = nil
+	_, err := sr.Write([]byte("fourth"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(22), sr.BytesWritten)
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name           string
+		write          func(sr *StatusRecorder)
+		expectedStatus int
+	}{
+		{"responses never written default to 200", func(sr *StatusRecorder) {}, http.StatusOK},
+		{"writes without a status record 200", func(sr *StatusRecorder) { _, _ = sr.Write([]byte("body")) }, http.StatusOK},
+		{"written statuses are recorded", func(sr *StatusRecorder) { sr.WriteHeader(http.StatusNoContent) }, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			sr := &StatusRecorder{ResponseWriter: recorder}
+			test.write(sr)
+			assert.Equal(t, test.expectedStatus, sr.Status())
+			assert.Equal(t, recorder.Result().StatusCode, sr.Status())
+		})
+	}
+}
+
 func TestFetchRoutePathTemplate(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -76,3 +121,50 @@ func TestFetchRoutePathTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestMuxRouteResolver(t *testing.T) {
+	pathTemplate := ""
+	router := mux.NewRouter()
+	router.HandleFunc("/path/{param}", func(w http.ResponseWriter, r *http.Request) {
+		pathTemplate = MuxRouteResolver.ResolveRoute(r)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path/1", nil))
+	assert.Equal(t, "/path/{param}", pathTemplate)
+
+	// Requests which were not routed through mux resolve to an empty string
+	assert.Equal(t, "", MuxRouteResolver.ResolveRoute(httptest.NewRequest("GET", "/path/1", nil)))
+}
+
+func TestServeMuxRouteResolver(t *testing.T) {
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {})
+	serveMux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	resolver := ServeMuxRouteResolver(serveMux)
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		expectedRoute string
+	}{
+		{"prefix patterns resolve to the request path", "GET", "/static/css/app.css", "/static/css/app.css"},
+		{"exact patterns resolve to the request path", "GET", "/health", "/health"},
+		{"unmatched requests resolve to an empty string", "GET", "/unknown", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expectedRoute, resolver.ResolveRoute(httptest.NewRequest(test.method, test.path, nil)))
+		})
+	}
+}
+
+func TestRouteResolverMiddleware(t *testing.T) {
+	customResolver := RouteResolverFunc(func(r *http.Request) string {
+		return "/custom/{param}"
+	})
+	pathTemplate := ""
+	handler := RouteResolverMiddleware(customResolver)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pathTemplate = FetchRoutePathTemplate(r)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/custom/1", nil))
+	assert.Equal(t, "/custom/{param}", pathTemplate)
+}
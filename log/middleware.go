@@ -64,7 +64,7 @@ func HTTPServerMiddleware(next http.Handler) http.Handler {
 		defer func() {
 			var responseCodeField zap.Field
 			if statusRecorder, ok := w.(*writer.StatusRecorder); ok {
-				responseCodeField = zap.Int("http.status_code", statusRecorder.StatusCode)
+				responseCodeField = zap.Int("http.status_code", statusRecorder.Status())
 			} else {
 				responseCodeField = zap.Skip()
 			}
@@ -68,7 +68,7 @@ func (c Config) ServerCmd(
 	// HTTP Config
 	httpConfig := shHTTP.NewDefaultConfig(c.Name)
 	httpConfig.Middleware = []mux.MiddlewareFunc{
-		tracing.HTTPServerMiddleware,
+		tracing.NewHTTPServerMiddleware(tracing.WithVersion(c.Version)),
 		shHTTP.NewMetrics(c.Registry, true).Middleware,
 		log.HTTPServerMiddleware,
 		sentry.NewMiddleware().HTTP,
@@ -97,7 +97,7 @@ func (c Config) ServerCmd(
 	// Sentry Config
 	sc := sentry.Config{AppVersion: c.Version}
 	// Tracing Config
-	tc := tracing.Config{ServiceName: c.Name}
+	tc := tracing.Config{ServiceName: c.Name, Version: c.Version, BuildRevision: c.GitSHA}
 	// Jose Config
 	jc := jose.Config{
 		ClaimGenerators: []jose.ClaimGenerator{
@@ -24,7 +24,20 @@ func (c *Config) RegisterFlags(flags *pflag.FlagSet) {
 	flags.BoolVar(&c.ReporterLogSpans, "tracer-reporter-log-spans", false, "Tracer Reporter Logs Spans")
 	flags.IntVar(&c.ReporterMaxQueueSize, "tracer-reporter-max-queue-size", 100, "Tracer Reporter Max Queue Size")
 	flags.DurationVar(&c.ReporterFlushInterval, "tracer-reporter-flush-interval", 1000000000, "Tracer Reporter Flush Interval in nanoseconds")
+	flags.IntVar(&c.ReporterMaxPacketSize, "tracer-reporter-max-packet-size", 0, "Tracer Reporter Max UDP Packet Size in bytes. Defaults to 65000 if not set.")
 	flags.StringVar(&c.AgentHost, "tracer-agent-host", "localhost", "Tracer Agent Host")
 	flags.IntVar(&c.AgentPort, "tracer-agent-port", 5775, "Tracer Agent Port")
+	flags.DurationVar(&c.AgentReconnectInterval, "tracer-agent-reconnect-interval", 0, "Interval at which Tracer Agent addresses are re-resolved. Disabled if 0")
+	flags.StringVar(&c.AgentSocketPath, "tracer-agent-socket-path", "", "Path of the Unix socket on which the Tracer Agent listens. Overrides tracer-agent-host when set.")
+	flags.StringSliceVar(&c.AgentHosts, "tracer-agent-hosts", []string{}, "Tracer Agent Hosts to report all spans to. Overrides tracer-agent-host when set.")
 	flags.StringVar(&c.ServiceName, "tracer-service-name", c.ServiceName, "Determines the service name for the Tracer UI")
+	flags.BoolVar(&c.BuildInfoTags, "tracer-build-info-tags", false, "Tag the Go runtime version, build version, and build revision on the Tracer process")
+	flags.BoolVar(&c.BaggageRestrictionsEnabled, "tracer-baggage-restrictions-enabled", false, "Enable Tracer baggage restrictions retrieved from the agent")
+	flags.StringVar(&c.BaggageRestrictionsHostPort, "tracer-baggage-restrictions-host-port", "", "Tracer baggage restrictions agent host:port. Defaults to localhost:5778")
+	flags.DurationVar(&c.BaggageRestrictionsRefreshInterval, "tracer-baggage-restrictions-refresh-interval", 0, "Tracer baggage restrictions refresh interval. Defaults to 1 minute")
+	flags.BoolVar(&c.BaggageRestrictionsDenyOnFailure, "tracer-baggage-restrictions-deny-on-failure", false, "Deny all baggage until Tracer baggage restrictions are retrieved")
+	flags.StringVar(&c.Exporter, "tracer-exporter", ExporterJaeger, "Tracer span exporter, either jaeger or otlp")
+	flags.StringVar(&c.OTLPProtocol, "tracer-otlp-protocol", OTLPProtocolHTTP, "Tracer OTLP exporter protocol. Only http is supported")
+	flags.StringVar(&c.OTLPEndpoint, "tracer-otlp-endpoint", "", "Tracer OTLP exporter endpoint URL, such as http://collector:4318/v1/traces")
+	flags.BoolVar(&c.DisableGlobalTracer, "tracer-disable-global-tracer", false, "Do not register the Tracer as the OpenTracing global tracer")
 }
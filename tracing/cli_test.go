@@ -53,6 +53,10 @@ func TestRegisterFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, time.Duration(1000000000), trfi)
 
+	trmps, err := flags.GetInt("tracer-reporter-max-packet-size")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, trmps)
+
 	tah, err := flags.GetString("tracer-agent-host")
 	assert.NoError(t, err)
 	assert.Equal(t, "localhost", tah)
@@ -61,7 +65,55 @@ func TestRegisterFlags(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 5775, tap)
 
+	tari, err := flags.GetDuration("tracer-agent-reconnect-interval")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), tari)
+
+	tasp, err := flags.GetString("tracer-agent-socket-path")
+	assert.NoError(t, err)
+	assert.Equal(t, "", tasp)
+
+	tahs, err := flags.GetStringSlice("tracer-agent-hosts")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, tahs)
+
 	tsn, err := flags.GetString("tracer-service-name")
 	assert.NoError(t, err)
 	assert.Equal(t, "", tsn)
+
+	tbit, err := flags.GetBool("tracer-build-info-tags")
+	assert.NoError(t, err)
+	assert.False(t, tbit)
+
+	tbre, err := flags.GetBool("tracer-baggage-restrictions-enabled")
+	assert.NoError(t, err)
+	assert.False(t, tbre)
+
+	tbrhp, err := flags.GetString("tracer-baggage-restrictions-host-port")
+	assert.NoError(t, err)
+	assert.Equal(t, "", tbrhp)
+
+	tbrri, err := flags.GetDuration("tracer-baggage-restrictions-refresh-interval")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), tbrri)
+
+	tbrdof, err := flags.GetBool("tracer-baggage-restrictions-deny-on-failure")
+	assert.NoError(t, err)
+	assert.False(t, tbrdof)
+
+	tex, err := flags.GetString("tracer-exporter")
+	assert.NoError(t, err)
+	assert.Equal(t, "jaeger", tex)
+
+	top, err := flags.GetString("tracer-otlp-protocol")
+	assert.NoError(t, err)
+	assert.Equal(t, "http", top)
+
+	toe, err := flags.GetString("tracer-otlp-endpoint")
+	assert.NoError(t, err)
+	assert.Equal(t, "", toe)
+
+	tdgt, err := flags.GetBool("tracer-disable-global-tracer")
+	assert.NoError(t, err)
+	assert.False(t, tdgt)
 }
@@ -0,0 +1,38 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"io"
+)
+
+// TraceCopy copies from src to dst with io.Copy within a span with the given name, so that the
+// duration and size of large transfers, such as streaming uploads and downloads, are visible in
+// traces. The span is tagged with the following tags:
+// * component - Always set to "io"
+// * bytes_copied - Always set to the number of bytes copied, even if the copy failed
+// * error - Set to true only if the copy failed
+func TraceCopy(ctx context.Context, dst io.Writer, src io.Reader, name string) (int64, error) {
+	span, _ := StartSpanFromContext(ctx, name)
+	defer span.Finish()
+	span = span.SetTag("component", "io")
+	written, err := io.Copy(dst, src)
+	span = span.SetTag("bytes_copied", written)
+	if err != nil {
+		span.SetTag("error", true)
+	}
+	return written, err
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingReader returns its data followed by an error
+type failingReader struct {
+	data string
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, fr.data)
+	fr.data = fr.data[n:]
+	return n, nil
+}
+
+func TestTraceCopy(t *testing.T) {
+	tests := []struct {
+		name        string
+		src         io.Reader
+		expectBytes int64
+		expectErr   bool
+	}{
+		{"the number of bytes copied is tagged", strings.NewReader("hello world"), 11, false},
+		{"failed copies tag the bytes copied before the failure", &failingReader{data: "hello"}, 5, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			var buf bytes.Buffer
+			written, err := TraceCopy(context.Background(), &buf, test.src, "download")
+			assert.Equal(t, test.expectBytes, written)
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "download", spans[0].OperationName)
+			assert.Equal(t, "io", spans[0].Tag("component"))
+			assert.Equal(t, test.expectBytes, spans[0].Tag("bytes_copied"))
+			if test.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, true, spans[0].Tag("error"))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "hello world", buf.String())
+				assert.Nil(t, spans[0].Tag("error"))
+			}
+		})
+	}
+}
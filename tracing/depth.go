@@ -0,0 +1,86 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/uber/jaeger-client-go"
+)
+
+// traceDepthBaggageKey is the baggage item counting the service hops of a trace
+const traceDepthBaggageKey = "trace.depth"
+
+// DepthSampling thins sampled traces as they grow deeper, since deeply nested service call chains
+// multiply span volume. Requests at a depth of up to FullDepth hops from the root of the trace are
+// sampled as decided upstream, while requests deeper than FullDepth remain sampled with
+// probability Decay raised to the number of hops beyond FullDepth. A FullDepth of 2 and a Decay of
+// 0.5, for example, keep every sampled request at the first three services of a trace and half
+// of those at the fourth.
+type DepthSampling struct {
+	FullDepth int
+	Decay     float64
+}
+
+// WithDepthSampling enables depth sampling. The depth of every request is propagated to
+// downstream services in the trace.depth baggage item, which is incremented by the middleware of
+// every service with depth sampling enabled; hops through services without it are not counted.
+// Unlike other local sampling decisions, depth sampling may unsample a request sampled by an
+// upstream service, leaving the deep remainder of the trace unreported. Requests never sampled
+// upstream, debug traces, and requests carrying a jaeger debug ID are unaffected. Disabled by
+// default.
+func WithDepthSampling(config DepthSampling) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.depthSampling = &config
+	}
+}
+
+// traceDepth returns the number of hops between the root of the trace and a request with the given
+// extracted span context. Requests which do not continue a trace are at depth 0, and requests
+// continuing a trace without a depth are assumed to be one hop from the root.
+func traceDepth(wireContext opentracing.SpanContext) int {
+	if !hasUpstreamSamplingDecision(wireContext) {
+		return 0
+	}
+	depth := 0
+	wireContext.ForeachBaggageItem(func(k, v string) bool {
+		if k == traceDepthBaggageKey {
+			if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 {
+				depth = parsed
+			}
+			return false
+		}
+		return true
+	})
+	return depth + 1
+}
+
+// depthSamplingPriority returns a sampling.priority span tag unsampling the request if a request
+// sampled upstream at the given depth is thinned by depth sampling. Debug traces, and requests
+// carrying a jaeger debug ID, are never unsampled.
+func (o middlewareOptions) depthSamplingPriority(r *http.Request, wireContext opentracing.SpanContext, depth int) (opentracing.Tag, bool) {
+	sc, ok := wireContext.(jaeger.SpanContext)
+	if !ok || !sc.IsSampled() || sc.IsDebug() || hasDebugID(r) || depth <= o.depthSampling.FullDepth {
+		return opentracing.Tag{}, false
+	}
+	if o.random() < math.Pow(o.depthSampling.Decay, float64(depth-o.depthSampling.FullDepth)) {
+		return opentracing.Tag{}, false
+	}
+	return opentracing.Tag{Key: string(ext.SamplingPriority), Value: uint16(0)}, true
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestHTTPServerMiddlewareDepthSampling(t *testing.T) {
+	tests := []struct {
+		name            string
+		tracerSampled   bool
+		random          float64
+		expectedDepths  []string
+		expectedSampled []bool
+	}{
+		{
+			"shallow hops are fully sampled and deep hops are thinned",
+			true,
+			0.4,
+			[]string{"0", "1", "2", "3", "4"},
+			// Hops 2, 3, and 4 are kept with probabilities 0.5, 0.25, and 0.125
+			[]bool{true, true, true, false, false},
+		},
+		{
+			"deep hops are kept when sampled by the decayed probability",
+			true,
+			0.2,
+			[]string{"0", "1", "2", "3", "4"},
+			[]bool{true, true, true, true, false},
+		},
+		{
+			"traces never sampled are unaffected",
+			false,
+			0.0,
+			[]string{"0", "1", "2", "3", "4"},
+			[]bool{false, false, false, false, false},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(test.tracerSampled), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+
+			var depths []string
+			var sampled []bool
+			var outbound http.Header
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				span := opentracing.SpanFromContext(r.Context())
+				depths = append(depths, span.BaggageItem("trace.depth"))
+				sampled = append(sampled, span.Context().(jaeger.SpanContext).IsSampled())
+				// Each hop calls the next service with the trace headers of its span
+				outbound = http.Header{}
+				require.NoError(t, tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(outbound)))
+			})
+			mw := NewHTTPServerMiddleware(
+				WithTracer(tracer),
+				WithDepthSampling(DepthSampling{FullDepth: 1, Decay: 0.5}),
+				func(o *middlewareOptions) { o.random = func() float64 { return test.random } },
+			)
+			for hop := 0; hop < 5; hop++ {
+				req := httptest.NewRequest("GET", "/hop", nil)
+				for key, values := range outbound {
+					req.Header[key] = values
+				}
+				mw(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+			}
+			assert.Equal(t, test.expectedDepths, depths)
+			assert.Equal(t, test.expectedSampled, sampled)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareDepthSamplingDebug(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceHeader string
+		debugID     string
+	}{
+		{"debug traces beyond the full depth are not unsampled", "1f2e3d4c5b6a7980:1f2e3d4c5b6a7980:0:3", ""},
+		{"requests with a debug ID beyond the full depth are not unsampled", "1f2e3d4c5b6a7980:1f2e3d4c5b6a7980:0:1", "support-ticket-123"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+
+			var sampled bool
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sampled = opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext).IsSampled()
+			})
+			req := httptest.NewRequest("GET", "/hop", nil)
+			req.Header.Set(jaeger.TraceContextHeaderName, test.traceHeader)
+			req.Header.Set(jaeger.TraceBaggageHeaderPrefix+"trace.depth", "5")
+			if test.debugID != "" {
+				req.Header.Set(jaeger.JaegerDebugHeader, test.debugID)
+			}
+			NewHTTPServerMiddleware(
+				WithTracer(tracer),
+				WithDepthSampling(DepthSampling{FullDepth: 1, Decay: 0.5}),
+				func(o *middlewareOptions) { o.random = func() float64 { return 0.99 } },
+			)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+			assert.True(t, sampled)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sync"
+	"time"
+)
+
+// errorRateBuckets is the number of buckets into which the error rate sampling window is divided
+const errorRateBuckets = 10
+
+// ErrorRateSampling configures adaptive sampling of HTTP requests based on the recent error rate
+// of the HTTP server middleware. While the ratio of errored responses over the sliding Window is
+// at or above Threshold, requests are sampled with probability Rate. As errors age out of the
+// window, the error rate decays and sampling returns to the other sampling rules and the tracer
+// sampler. The error rate is only considered once at least MinRequests requests fall within the
+// window, so that a single failed request on an idle service does not raise sampling.
+type ErrorRateSampling struct {
+	Window      time.Duration
+	Threshold   float64
+	MinRequests int
+	Rate        float64
+}
+
+// errorRateBucket counts the requests and errors observed during one slice of the window
+type errorRateBucket struct {
+	epoch    int64
+	requests int
+	errors   int
+}
+
+// errorRateTracker tracks the error rate of requests over a sliding window
+type errorRateTracker struct {
+	ErrorRateSampling
+	mutex   sync.Mutex
+	buckets [errorRateBuckets]errorRateBucket
+}
+
+// newErrorRateTracker creates an errorRateTracker for the given configuration
+func newErrorRateTracker(config ErrorRateSampling) *errorRateTracker {
+	return &errorRateTracker{ErrorRateSampling: config}
+}
+
+// epoch returns the index of the window slice containing the given time
+func (ert *errorRateTracker) epoch(now time.Time) int64 {
+	width := int64(ert.Window) / errorRateBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return now.UnixNano() / width
+}
+
+// record counts a request, and whether it errored, at the given time
+func (ert *errorRateTracker) record(now time.Time, errored bool) {
+	ert.mutex.Lock()
+	defer ert.mutex.Unlock()
+	epoch := ert.epoch(now)
+	bucket := &ert.buckets[epoch%errorRateBuckets]
+	if bucket.epoch != epoch {
+		*bucket = errorRateBucket{epoch: epoch}
+	}
+	bucket.requests++
+	if errored {
+		bucket.errors++
+	}
+}
+
+// elevated returns true if the error rate over the window ending at the given time is at or
+// above the threshold
+func (ert *errorRateTracker) elevated(now time.Time) bool {
+	ert.mutex.Lock()
+	defer ert.mutex.Unlock()
+	epoch := ert.epoch(now)
+	requests, errors := 0, 0
+	for _, bucket := range ert.buckets {
+		if bucket.epoch > epoch-errorRateBuckets && bucket.epoch <= epoch {
+			requests += bucket.requests
+			errors += bucket.errors
+		}
+	}
+	if requests == 0 || requests < ert.MinRequests {
+		return false
+	}
+	return float64(errors)/float64(requests) >= ert.Threshold
+}
+
+// WithErrorRateSampling samples requests at an elevated rate while the recent error rate of the
+// middleware is high, capturing more traces exactly when things go wrong. Errors are counted
+// from response status codes as configured by WithErrorOnClientError, so the response writer must
+// be a writer.StatusRecorder. Active sampling overrides take precedence over error rate sampling,
+// which takes precedence over user sampling and the sampling rules. Disabled by default.
+func WithErrorRateSampling(config ErrorRateSampling) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.errorRate = newErrorRateTracker(config)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/spothero/tools/http/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestErrorRateTracker(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		requests       int
+		errors         int
+		at             time.Duration
+		expectElevated bool
+	}{
+		{"no requests are not elevated", 0, 0, 0, false},
+		{"error rates below the threshold are not elevated", 10, 4, 0, false},
+		{"error rates at the threshold are elevated", 10, 5, 0, true},
+		{"too few requests are not elevated", 2, 2, 0, false},
+		{"errors within the window are counted", 10, 10, 50 * time.Second, true},
+		{"errors outside the window decay", 10, 10, 70 * time.Second, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ert := newErrorRateTracker(ErrorRateSampling{Window: time.Minute, Threshold: 0.5, MinRequests: 5})
+			for i := 0; i < test.requests; i++ {
+				ert.record(start, i < test.errors)
+			}
+			assert.Equal(t, test.expectElevated, ert.elevated(start.Add(test.at)))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareErrorRateSampling(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var sampled bool
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+		require.True(t, ok)
+		sampled = spanCtx.IsSampled()
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	mw := NewHTTPServerMiddleware(
+		WithTracer(tracer),
+		WithClock(func() time.Time { return now }),
+		WithErrorRateSampling(ErrorRateSampling{Window: time.Minute, Threshold: 0.5, MinRequests: 4, Rate: 1.0}),
+	)
+	handler := writer.StatusRecorderMiddleware(mw(testHandler))
+	serve := func(path string) bool {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		return sampled
+	}
+
+	assert.False(t, serve("/ok"), "requests are not sampled while the error rate is low")
+	for i := 0; i < 4; i++ {
+		serve("/fail")
+	}
+	assert.True(t, serve("/ok"), "requests are sampled while the error rate is high")
+
+	now = now.Add(2 * time.Minute)
+	assert.False(t, serve("/ok"), "sampling decays once errors leave the window")
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exemplar
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spothero/tools/tracing"
+	"github.com/uber/jaeger-client-go"
+)
+
+// TraceIDLabel is the name of the exemplar label which holds the trace ID
+const TraceIDLabel = "trace_id"
+
+// TraceExemplar returns the exemplar labels linking a metric observation to the trace of the
+// span on the given context, so that dashboards may jump from a metric to an example trace. Only
+// sampled spans are linked, since unsampled traces are never reported. Nil is returned if the
+// context carries no sampled Jaeger span.
+func TraceExemplar(ctx context.Context) prometheus.Labels {
+	if !tracing.IsSampled(ctx) {
+		return nil
+	}
+	sc, ok := opentracing.SpanFromContext(ctx).Context().(jaeger.SpanContext)
+	if !ok {
+		return nil
+	}
+	return prometheus.Labels{TraceIDLabel: sc.TraceID().String()}
+}
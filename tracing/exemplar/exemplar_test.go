@@ -0,0 +1,58 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exemplar
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-client-go"
+)
+
+func TestTraceExemplar(t *testing.T) {
+	tests := []struct {
+		name      string
+		sampled   bool
+		withSpan  bool
+		expectNil bool
+	}{
+		{"contexts without spans have no exemplar", true, false, true},
+		{"unsampled spans have no exemplar", false, true, true},
+		{"sampled spans are returned as exemplars", true, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(test.sampled), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+			ctx := context.Background()
+			var span opentracing.Span
+			if test.withSpan {
+				span, ctx = opentracing.StartSpanFromContextWithTracer(ctx, tracer, "test")
+				defer span.Finish()
+			}
+
+			exemplar := TraceExemplar(ctx)
+			if test.expectNil {
+				assert.Nil(t, exemplar)
+				return
+			}
+			traceID := span.Context().(jaeger.SpanContext).TraceID().String()
+			assert.Equal(t, prometheus.Labels{TraceIDLabel: traceID}, exemplar)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"golang.org/x/sync/errgroup"
+)
+
+// GoWithSpan runs fn in a new goroutine of the errgroup within a child span of the span on the
+// given context. The span is finished when fn returns and is tagged as errored if fn returns an
+// error. The context passed to fn carries the child span. If spans are verbose on the given
+// context, the start and outcome of fn are logged on the span.
+func GoWithSpan(ctx context.Context, g *errgroup.Group, name string, fn func(context.Context) error) {
+	g.Go(func() error {
+		span, spanCtx := StartSpanFromContext(ctx, name)
+		defer span.Finish()
+		verbose := IsSpanVerbose(ctx)
+		if verbose {
+			span.LogKV("event", "goroutine started")
+		}
+		if err := fn(spanCtx); err != nil {
+			if verbose {
+				span.LogKV("event", "goroutine failed", "error", err.Error())
+			}
+			span.SetTag("error", true)
+			return err
+		}
+		if verbose {
+			span.LogKV("event", "goroutine completed")
+		}
+		return nil
+	})
+}
+
+// detachedContext carries the values of its parent context but is never canceled and has no
+// deadline, so that work outliving the parent is not interrupted when the parent is canceled
+type detachedContext struct {
+	parent context.Context
+}
+
+// Deadline implements context.Context; detached contexts have no deadline
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+// Done implements context.Context; detached contexts are never canceled
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+// Err implements context.Context; detached contexts are never canceled
+func (detachedContext) Err() error { return nil }
+
+// Value implements context.Context, returning the values of the parent context
+func (dc detachedContext) Value(key interface{}) interface{} { return dc.parent.Value(key) }
+
+// DetachSpan starts a span for background work which may outlive the span on the given context,
+// such as work run in a goroutine after an HTTP response is written. The span references the span
+// on the given context with a FollowsFrom relationship rather than as a child, since the parent
+// may finish first. The returned context carries the new span and all values of the given context,
+// such as the logger, but is detached from its cancellation and deadline. The returned function
+// finishes the span and must be called once the background work completes; spans which are never
+// finished are never reported.
+func DetachSpan(ctx context.Context, operationName string) (context.Context, func()) {
+	tracer := tracerForContext(ctx, contextTracer(ctx))
+	var opts []opentracing.StartSpanOption
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		opts = append(opts, opentracing.FollowsFrom(parent.Context()))
+	}
+	span := tracer.StartSpan(operationName, opts...)
+	detachedCtx := opentracing.ContextWithSpan(detachedContext{parent: ctx}, span)
+	return EmbedCorrelationID(detachedCtx), span.Finish
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestGoWithSpan(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	parent, ctx := opentracing.StartSpanFromContext(context.Background(), "parent")
+	g, gCtx := errgroup.WithContext(ctx)
+	GoWithSpan(gCtx, g, "first", func(ctx context.Context) error {
+		assert.NotNil(t, opentracing.SpanFromContext(ctx))
+		return nil
+	})
+	GoWithSpan(gCtx, g, "second", func(ctx context.Context) error {
+		return fmt.Errorf("second failed")
+	})
+	assert.Error(t, g.Wait())
+	parent.Finish()
+
+	spans := make(map[string]*mocktracer.MockSpan)
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+	}
+	require.Len(t, spans, 3)
+	parentSpanID := spans["parent"].Context().(mocktracer.MockSpanContext).SpanID
+	assert.Equal(t, parentSpanID, spans["first"].ParentID)
+	assert.Equal(t, parentSpanID, spans["second"].ParentID)
+	assert.Nil(t, spans["first"].Tag("error"))
+	assert.Equal(t, true, spans["second"].Tag("error"))
+}
+
+func TestDetachSpan(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	parent, ctx := opentracing.StartSpanFromContext(context.Background(), "parent")
+	ctx, cancel := context.WithCancel(ctx)
+	detachedCtx, finish := DetachSpan(ctx, "background")
+
+	// Finishing and canceling the parent must not affect the detached work
+	parent.Finish()
+	cancel()
+	assert.NoError(t, detachedCtx.Err())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer finish()
+		assert.NotNil(t, opentracing.SpanFromContext(detachedCtx))
+	}()
+	<-done
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "parent", spans[0].OperationName)
+	assert.Equal(t, "background", spans[1].OperationName)
+	assert.Equal(t, spans[0].SpanContext.SpanID, spans[1].ParentID)
+}
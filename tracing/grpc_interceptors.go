@@ -18,7 +18,10 @@ import (
 	"context"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/opentracing/opentracing-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // UnaryServerInterceptor returns a new unary server interceptor that adds the correlation_id to
@@ -46,6 +49,12 @@ func StreamServerInterceptor(srv interface{}, stream grpc.ServerStream, info *gr
 // interceptor to ensure that an opentracing context is present on the context. Additionally, this
 // interceptor should always appear *before* the logging interceptor to ensure that the
 // correlation_id is properly logged.
+//
+// The client span is tagged with the following tags:
+// * grpc.method - The full gRPC method name
+// * grpc.status_code - The gRPC status code of the call
+// * grpc.deadline_exceeded - Set to true only if the call deadline was exceeded
+// * grpc.canceled - Set to true only if the call was canceled by the client
 func UnaryClientInterceptor(
 	parentCtx context.Context,
 	method string,
@@ -54,7 +63,22 @@ func UnaryClientInterceptor(
 	invoker grpc.UnaryInvoker,
 	opts ...grpc.CallOption,
 ) error {
-	return invoker(EmbedCorrelationID(parentCtx), method, req, reply, cc, opts...)
+	span := opentracing.SpanFromContext(parentCtx)
+	if span != nil {
+		span = span.SetTag("grpc.method", method)
+	}
+	err := invoker(EmbedCorrelationID(parentCtx), method, req, reply, cc, opts...)
+	if span != nil {
+		code := status.Code(err)
+		span = span.SetTag("grpc.status_code", code.String())
+		switch code {
+		case codes.Canceled:
+			span.SetTag("grpc.canceled", true)
+		case codes.DeadlineExceeded:
+			span.SetTag("grpc.deadline_exceeded", true)
+		}
+	}
+	return err
 }
 
 // StreamClientInterceptor returns a new unary client interceptor that adds the correlation_id to
@@ -16,13 +16,22 @@ package tracing
 
 import (
 	"context"
+	"net"
 	"testing"
+	"time"
 
+	grpcot "github.com/grpc-ecosystem/go-grpc-middleware/tracing/opentracing"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	grpcmock "github.com/spothero/tools/grpc/mock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	jaeger "github.com/uber/jaeger-client-go"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestUnaryServerInterceptor(t *testing.T) {
@@ -115,3 +124,81 @@ func TestStreamClientInterceptor(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, stream)
 }
+
+// testHealthServer responds to health checks based on the requested service name
+type testHealthServer struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (*testHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	switch req.Service {
+	case "error":
+		return nil, status.Error(codes.Internal, "internal error")
+	case "slow":
+		<-ctx.Done()
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func TestUnaryClientInterceptorTags(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, &testHealthServer{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	tests := []struct {
+		name             string
+		service          string
+		cancel           bool
+		timeout          time.Duration
+		expectedCode     codes.Code
+		expectedCanceled interface{}
+		expectedDeadline interface{}
+	}{
+		{"successful calls are tagged with the status code", "ok", false, 0, codes.OK, nil, nil},
+		{"server errors are tagged with the status code", "error", false, 0, codes.Internal, nil, nil},
+		{"exceeded deadlines are tagged", "slow", false, 10 * time.Millisecond, codes.DeadlineExceeded, nil, true},
+		{"cancellations are tagged", "slow", true, 0, codes.Canceled, true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			conn, err := grpc.Dial(
+				"bufnet",
+				grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+					return listener.Dial()
+				}),
+				grpc.WithInsecure(),
+				grpc.WithChainUnaryInterceptor(
+					grpcot.UnaryClientInterceptor(grpcot.WithTracer(tracer)),
+					UnaryClientInterceptor,
+				),
+			)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			if test.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: test.service})
+			assert.Equal(t, test.expectedCode, status.Code(err))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "/grpc.health.v1.Health/Check", spans[0].Tag("grpc.method"))
+			assert.Equal(t, test.expectedCode.String(), spans[0].Tag("grpc.status_code"))
+			assert.Equal(t, test.expectedCanceled, spans[0].Tag("grpc.canceled"))
+			assert.Equal(t, test.expectedDeadline, spans[0].Tag("grpc.deadline_exceeded"))
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+
+	"github.com/gorilla/mux"
+)
+
+// handlerNameCtxKeyType is the type used to uniquely place the handler name holder in contexts
+type handlerNameCtxKeyType int
+
+// handlerNameCtxKey is the key into any context.Context which maps to the handler name holder
+const handlerNameCtxKey handlerNameCtxKeyType = iota
+
+// handlerName returns the name of the given handler. The function name is returned for handler
+// functions, otherwise the type of the handler is returned.
+func handlerName(handler http.Handler) string {
+	if fn, ok := handler.(http.HandlerFunc); ok {
+		if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return fmt.Sprintf("%T", handler)
+}
+
+// SetHandlerName records the name of the handler serving the request on the given context, for
+// use as the http.handler tag when WithHandlerName is enabled. This is a no-op if the context did
+// not pass through the middleware with WithHandlerName enabled.
+func SetHandlerName(ctx context.Context, name string) {
+	if holder, ok := ctx.Value(handlerNameCtxKey).(*string); ok {
+		*holder = name
+	}
+}
+
+// NamedHandler wraps the given handler so that its name, resolved through reflection, is recorded
+// with SetHandlerName for every request. This allows the http.handler tag to be set for handlers
+// not registered through gorilla mux.
+func NamedHandler(handler http.Handler) http.Handler {
+	name := handlerName(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetHandlerName(r.Context(), name)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// routeHandlerName returns the name of the handler of the gorilla mux route matched by the
+// request, or an empty string if no route was matched
+func routeHandlerName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil && route.GetHandler() != nil {
+		return handlerName(route.GetHandler())
+	}
+	return ""
+}
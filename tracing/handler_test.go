@@ -0,0 +1,98 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNamedHandler(w http.ResponseWriter, r *http.Request) {}
+
+type testHandlerType struct{}
+
+func (testHandlerType) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func TestHTTPServerMiddlewareHandlerName(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            []MiddlewareOption
+		useMux          bool
+		handler         http.Handler
+		expectedHandler interface{}
+	}{
+		{
+			"handler names are not tagged by default",
+			nil,
+			true,
+			http.HandlerFunc(testNamedHandler),
+			nil,
+		},
+		{
+			"mux handler functions are tagged with the function name",
+			[]MiddlewareOption{WithHandlerName(true)},
+			true,
+			http.HandlerFunc(testNamedHandler),
+			"github.com/spothero/tools/tracing.testNamedHandler",
+		},
+		{
+			"mux handlers are tagged with the handler type",
+			[]MiddlewareOption{WithHandlerName(true)},
+			true,
+			testHandlerType{},
+			"tracing.testHandlerType",
+		},
+		{
+			"named handlers are tagged without mux",
+			[]MiddlewareOption{WithHandlerName(true)},
+			false,
+			NamedHandler(http.HandlerFunc(testNamedHandler)),
+			"github.com/spothero/tools/tracing.testNamedHandler",
+		},
+		{
+			"unresolvable handlers are not tagged",
+			[]MiddlewareOption{WithHandlerName(true)},
+			false,
+			http.HandlerFunc(testNamedHandler),
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			middleware := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			var handler http.Handler
+			if test.useMux {
+				router := mux.NewRouter()
+				router.Use(middleware)
+				router.Handle("/", test.handler)
+				handler = router
+			} else {
+				handler = middleware(test.handler)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedHandler, spans[0].Tag("http.handler"))
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+var (
+	spanFinishHooksMutex sync.RWMutex
+	spanFinishHooks      []func(opentracing.Span)
+)
+
+// RegisterSpanFinishHook registers a function which is called with every span finished by the HTTP
+// server and SQL middleware in this package, immediately after the span is finished. Hooks are
+// called synchronously in the order they were registered, so they must be fast and safe for
+// concurrent use. Hooks cannot be unregistered.
+func RegisterSpanFinishHook(hook func(opentracing.Span)) {
+	spanFinishHooksMutex.Lock()
+	defer spanFinishHooksMutex.Unlock()
+	spanFinishHooks = append(spanFinishHooks, hook)
+}
+
+// finishSpan finishes the given span with the given options and calls all registered span finish
+// hooks. The span is finished at the current time if no finish time is set.
+func finishSpan(span opentracing.Span, opts opentracing.FinishOptions) {
+	if opts.FinishTime.IsZero() {
+		opts.FinishTime = time.Now()
+	}
+	span.FinishWithOptions(opts)
+	spanFinishHooksMutex.RLock()
+	defer spanFinishHooksMutex.RUnlock()
+	for _, hook := range spanFinishHooks {
+		hook(span)
+	}
+}
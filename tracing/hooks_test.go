@@ -0,0 +1,59 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterSpanFinishHook(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	defer func() { spanFinishHooks = nil }()
+
+	var first, second []string
+	RegisterSpanFinishHook(func(span opentracing.Span) {
+		// The span must already be finished when hooks are called
+		assert.False(t, span.(*mocktracer.MockSpan).FinishTime.IsZero())
+		first = append(first, span.(*mocktracer.MockSpan).OperationName)
+	})
+	RegisterSpanFinishHook(func(span opentracing.Span) {
+		second = append(second, span.(*mocktracer.MockSpan).OperationName)
+	})
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, mwEnd, err := SQLMiddleware(r.Context(), "query", "SELECT 1")
+		require.NoError(t, err)
+		_, err = mwEnd(ctx, "query", "SELECT 1", nil)
+		require.NoError(t, err)
+	})
+	HTTPServerMiddleware(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	// Spans not finished by this package's middleware do not call the hooks
+	untracked, _ := opentracing.StartSpanFromContext(context.Background(), "untracked")
+	untracked.Finish()
+
+	// The HTTP span has an empty operation name since the request was not routed
+	assert.Equal(t, []string{"db_query", ""}, first)
+	assert.Equal(t, first, second)
+}
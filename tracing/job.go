@@ -0,0 +1,83 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// StartJobTrace starts a new trace for a run of a background job, such as a scheduled cron job,
+// which has no inbound request from which to continue a trace. The span is always a root span,
+// even if the given context already carries a span. Span names are in the format
+// "job_<jobName>" and job spans are tagged with the following tags:
+// * component - Always set to "cron"
+// * job.name - Always set to the job name
+//
+// The returned context carries the job span and the correlation ID of the new trace. The returned
+// function finishes the span and must be called once the job run completes. If the function is
+// deferred and the job panics, the span is tagged as errored, the panic value is logged on the
+// span, and the span is finished before the panic is resumed.
+func StartJobTrace(ctx context.Context, jobName string) (context.Context, func()) {
+	tracer := tracerForContext(ctx, contextTracer(ctx))
+	span := tracer.StartSpan(
+		fmt.Sprintf("job_%s", jobName),
+		opentracing.Tag{Key: "component", Value: "cron"},
+		opentracing.Tag{Key: "job.name", Value: jobName},
+	)
+	return EmbedCorrelationID(opentracing.ContextWithSpan(ctx, span)), finishRecovering(span)
+}
+
+// WorkerSpan starts a span for a job pulled off a queue by a worker, continuing the trace of the
+// job's producer from the given token, as produced by SerializeContext. The span references the
+// producer's span with a FollowsFrom relationship, since the producer does not wait on the job.
+// If the token is empty or cannot be decoded, the span starts a new trace so that the job is
+// still traced. Span names are in the format "worker_<jobName>" and worker spans are tagged with
+// the following tags:
+// * component - Always set to "worker"
+// * job.name - Always set to the job name
+//
+// The returned context carries the worker span and its correlation ID. The returned function
+// finishes the span and must be called once the job completes. As with StartJobTrace, deferring
+// the function finishes the span as errored if the job panics.
+func WorkerSpan(ctx context.Context, token, jobName string) (context.Context, func()) {
+	opts := []opentracing.StartSpanOption{
+		opentracing.Tag{Key: "component", Value: "worker"},
+		opentracing.Tag{Key: "job.name", Value: jobName},
+	}
+	if token != "" {
+		if producerCtx, err := DeserializeContext(ctx, token); err == nil {
+			opts = append(opts, opentracing.FollowsFrom(producerCtx.Value(remoteSpanCtxKey).(opentracing.SpanContext)))
+		}
+	}
+	span := tracerForContext(ctx, contextTracer(ctx)).StartSpan(fmt.Sprintf("worker_%s", jobName), opts...)
+	return EmbedCorrelationID(opentracing.ContextWithSpan(ctx, span)), finishRecovering(span)
+}
+
+// finishRecovering returns a function which finishes the given span. When the function is deferred
+// by a panicking goroutine, the panic is recovered so that the span can be tagged as errored, with
+// the panic value logged, and finished, after which the panic is resumed.
+func finishRecovering(span opentracing.Span) func() {
+	return func() {
+		if r := recover(); r != nil {
+			span.SetTag("error", true).LogKV("event", "panic", "panic", fmt.Sprint(r))
+			span.Finish()
+			panic(r)
+		}
+		span.Finish()
+	}
+}
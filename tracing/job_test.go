@@ -0,0 +1,125 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+func TestStartJobTrace(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	// Job spans are root spans even if the context already carries a span
+	parent, ctx := opentracing.StartSpanFromContext(context.Background(), "parent")
+	jobCtx, finish := StartJobTrace(ctx, "cleanup")
+	jobSpan := opentracing.SpanFromContext(jobCtx)
+	require.NotNil(t, jobSpan)
+	finish()
+	parent.Finish()
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "job_cleanup", spans[0].OperationName)
+	assert.Equal(t, 0, spans[0].ParentID)
+	assert.NotEqual(t, spans[1].SpanContext.TraceID, spans[0].SpanContext.TraceID)
+	assert.Equal(t, "cron", spans[0].Tag("component"))
+	assert.Equal(t, "cleanup", spans[0].Tag("job.name"))
+}
+
+func TestWorkerSpan(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	// The producer serializes its span context into the job payload
+	producer, producerCtx := opentracing.StartSpanFromContext(context.Background(), "producer")
+	token, err := SerializeContext(producerCtx)
+	require.NoError(t, err)
+	producer.Finish()
+
+	// The worker continues the producer's trace
+	workerCtx, finish := WorkerSpan(context.Background(), token, "cleanup")
+	worker := opentracing.SpanFromContext(workerCtx)
+	require.NotNil(t, worker)
+	finish()
+	producerSpanCtx := producer.Context().(jaeger.SpanContext)
+	workerSpanCtx := worker.Context().(jaeger.SpanContext)
+	assert.Equal(t, producerSpanCtx.TraceID(), workerSpanCtx.TraceID())
+	assert.Equal(t, producerSpanCtx.SpanID(), workerSpanCtx.ParentID())
+	workerSpan := jaeger.BuildJaegerThrift(worker.(*jaeger.Span))
+	assert.Equal(t, "worker_cleanup", workerSpan.OperationName)
+	require.Len(t, workerSpan.References, 1)
+	assert.Equal(t, j.SpanRefType_FOLLOWS_FROM, workerSpan.References[0].RefType)
+
+	// Jobs with invalid tokens start new traces
+	workerCtx, finish = WorkerSpan(context.Background(), "not a token", "cleanup")
+	finish()
+	orphanSpanCtx := opentracing.SpanFromContext(workerCtx).Context().(jaeger.SpanContext)
+	assert.NotEqual(t, producerSpanCtx.TraceID(), orphanSpanCtx.TraceID())
+	assert.Equal(t, jaeger.SpanID(0), orphanSpanCtx.ParentID())
+}
+
+func TestJobSpanPanics(t *testing.T) {
+	tests := []struct {
+		name         string
+		start        func(ctx context.Context) (context.Context, func())
+		expectedName string
+	}{
+		{"panicking jobs finish their span", func(ctx context.Context) (context.Context, func()) { return StartJobTrace(ctx, "cleanup") }, "job_cleanup"},
+		{"panicking workers finish their span", func(ctx context.Context) (context.Context, func()) { return WorkerSpan(ctx, "", "email") }, "worker_email"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			// The panic is resumed once the span is finished
+			assert.PanicsWithValue(t, "job failed", func() {
+				_, finish := test.start(context.Background())
+				defer finish()
+				panic("job failed")
+			})
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedName, spans[0].OperationName)
+			assert.Equal(t, true, spans[0].Tag("error"))
+			require.Len(t, spans[0].Logs(), 1)
+			fields := spans[0].Logs()[0].Fields
+			require.Len(t, fields, 2)
+			assert.Equal(t, "panic", fields[0].ValueString)
+			assert.Equal(t, "job failed", fields[1].ValueString)
+
+			// Jobs which complete normally are not tagged as errored
+			_, finish := test.start(context.Background())
+			finish()
+			spans = tracer.FinishedSpans()
+			require.Len(t, spans, 2)
+			assert.Nil(t, spans[1].Tag("error"))
+		})
+	}
+}
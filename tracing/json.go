@@ -0,0 +1,49 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// DecodeJSON decodes the JSON read from r into v within a span named "json_decode", so that the
+// cost of deserializing large payloads is visible in traces. The span is tagged with
+// component set to "json" and is tagged as errored if decoding fails.
+func DecodeJSON(ctx context.Context, r io.Reader, v interface{}) error {
+	span, _ := StartSpanFromContext(ctx, "json_decode")
+	defer span.Finish()
+	span = span.SetTag("component", "json")
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		span.SetTag("error", true)
+		return err
+	}
+	return nil
+}
+
+// EncodeJSON encodes v as JSON to w within a span named "json_encode", so that the cost of
+// serializing large payloads is visible in traces. The span is tagged with component set to
+// "json" and is tagged as errored if encoding fails.
+func EncodeJSON(ctx context.Context, w io.Writer, v interface{}) error {
+	span, _ := StartSpanFromContext(ctx, "json_encode")
+	defer span.Finish()
+	span = span.SetTag("component", "json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		span.SetTag("error", true)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expectErr bool
+	}{
+		{"valid json is decoded", `{"name": "test"}`, false},
+		{"invalid json returns an errored span", `{"name": `, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			var v struct {
+				Name string `json:"name"`
+			}
+			err := DecodeJSON(context.Background(), strings.NewReader(test.input), &v)
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "json_decode", spans[0].OperationName)
+			assert.Equal(t, "json", spans[0].Tag("component"))
+			if test.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, true, spans[0].Tag("error"))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "test", v.Name)
+				assert.Nil(t, spans[0].Tag("error"))
+			}
+		})
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     interface{}
+		expectErr bool
+	}{
+		{"valid values are encoded", map[string]string{"name": "test"}, false},
+		{"unsupported values return an errored span", make(chan int), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			var buf bytes.Buffer
+			err := EncodeJSON(context.Background(), &buf, test.input)
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "json_encode", spans[0].OperationName)
+			assert.Equal(t, "json", spans[0].Tag("component"))
+			if test.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, true, spans[0].Tag("error"))
+			} else {
+				assert.NoError(t, err)
+				assert.JSONEq(t, `{"name": "test"}`, buf.String())
+				assert.Nil(t, spans[0].Tag("error"))
+			}
+		})
+	}
+}
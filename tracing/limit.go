@@ -0,0 +1,78 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// spanLimitCtxKeyType is the type used to uniquely place span limits in contexts
+type spanLimitCtxKeyType int
+
+// spanLimitCtxKey is the key into any context.Context which maps to the span limit of a trace
+const spanLimitCtxKey spanLimitCtxKeyType = iota
+
+// spanLimit counts the spans started under a span, refusing new spans beyond the limit
+type spanLimit struct {
+	limit     int32
+	started   int32
+	truncated int32
+	parent    opentracing.Span
+}
+
+// acquire returns true if another span may be started. Once the limit is reached, the parent
+// span is tagged with spans.truncated set to true and false is returned. The count of started
+// spans never exceeds the limit, so that it cannot overflow on long-lived contexts.
+func (sl *spanLimit) acquire() bool {
+	for {
+		started := atomic.LoadInt32(&sl.started)
+		if started >= sl.limit {
+			break
+		}
+		if atomic.CompareAndSwapInt32(&sl.started, started, started+1) {
+			return true
+		}
+	}
+	if atomic.CompareAndSwapInt32(&sl.truncated, 0, 1) && sl.parent != nil {
+		sl.parent.SetTag("spans.truncated", true)
+	}
+	return false
+}
+
+// WithSpanLimit returns a context which limits the number of SQL middleware spans started from
+// it, and all contexts derived from it, to the given limit. Once the limit is reached, queries are
+// no longer traced and the span on the given context, if any, is tagged with spans.truncated set
+// to true. This protects the collector from pathological traces, such as runaway loops issuing
+// thousands of queries under a single request. Limits of zero or less are ignored.
+func WithSpanLimit(ctx context.Context, limit int) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, spanLimitCtxKey, &spanLimit{
+		limit:  int32(limit),
+		parent: opentracing.SpanFromContext(ctx),
+	})
+}
+
+// acquireSpan returns true if a span may be started on the given context under its span limit
+func acquireSpan(ctx context.Context) bool {
+	if sl, ok := ctx.Value(spanLimitCtxKey).(*spanLimit); ok {
+		return sl.acquire()
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithSpanLimit(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	parent, ctx := opentracing.StartSpanFromContext(context.Background(), "parent")
+	ctx = WithSpanLimit(ctx, 2)
+	for i := 0; i < 5; i++ {
+		queryCtx, mwEnd, err := SQLMiddleware(ctx, "getAllTests", "SELECT * FROM tests")
+		require.NoError(t, err)
+		_, err = mwEnd(queryCtx, "getAllTests", "SELECT * FROM tests", nil)
+		require.NoError(t, err)
+	}
+	parent.Finish()
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "db_getAllTests", spans[0].OperationName)
+	assert.Equal(t, "db_getAllTests", spans[1].OperationName)
+	assert.Equal(t, "parent", spans[2].OperationName)
+	assert.Equal(t, true, spans[2].Tag("spans.truncated"))
+
+	// Limits of zero are ignored
+	assert.Equal(t, ctx, WithSpanLimit(ctx, 0))
+}
+
+func TestSpanLimitAcquire(t *testing.T) {
+	// The count of started spans stops at the limit, so it never overflows and re-allows spans
+	sl := &spanLimit{limit: 2}
+	for i := 0; i < 5; i++ {
+		sl.acquire()
+	}
+	assert.Equal(t, int32(2), sl.started)
+	sl.started = math.MaxInt32
+	sl.limit = math.MaxInt32
+	assert.False(t, sl.acquire())
+	assert.Equal(t, int32(math.MaxInt32), sl.started)
+}
+
+func TestHTTPServerMiddlewareRequestSpanLimit(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              []MiddlewareOption
+		expectedSpans     int
+		expectedTruncated interface{}
+	}{
+		{"spans are not limited by default", nil, 4, nil},
+		{"spans beyond the limit are not started", []MiddlewareOption{WithRequestSpanLimit(1)}, 2, true},
+		{"requests within the limit are not truncated", []MiddlewareOption{WithRequestSpanLimit(3)}, 4, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < 3; i++ {
+					ctx, mwEnd, err := SQLMiddleware(r.Context(), "getAllTests", "SELECT * FROM tests")
+					require.NoError(t, err)
+					_, err = mwEnd(ctx, "getAllTests", "SELECT * FROM tests", nil)
+					require.NoError(t, err)
+				}
+			})
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, test.expectedSpans)
+			assert.Equal(t, test.expectedTruncated, spans[len(spans)-1].Tag("spans.truncated"))
+		})
+	}
+}
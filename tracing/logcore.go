@@ -0,0 +1,88 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ContextField returns a log field carrying the given context. When logged through a core
+// wrapped by NewTraceCore, the field is replaced with the trace_id, span_id, and sampled fields
+// of the span on the context, if any. Encoders skip the field otherwise, so it is always safe to
+// log, and may be attached once with logger.With to correlate every log of the logger, including
+// logs of libraries handed the logger.
+func ContextField(ctx context.Context) zap.Field {
+	return zap.Field{Key: "context", Type: zapcore.SkipType, Interface: ctx}
+}
+
+// traceCore is a zapcore.Core which resolves context fields to trace correlation fields
+type traceCore struct {
+	zapcore.Core
+}
+
+// NewTraceCore wraps the given core so that every log record carrying a ContextField is enriched
+// with the trace_id, span_id, and sampled fields of the active span on the context, as returned
+// by SpanLogFields. This is opt-in, and is installed when building the cores of the logger. Since
+// the trace core writes every enabled entry directly to the given core, it must wrap the cores
+// which write entries, within any sampling cores or tees, such as
+// zapcore.NewSampler(tracing.NewTraceCore(core), ...), so that sampling and the levels of teed
+// cores still apply. Wrapping a sampled logger with zap.WrapCore bypasses its sampling.
+func NewTraceCore(core zapcore.Core) zapcore.Core {
+	return traceCore{Core: core}
+}
+
+// With adds the given fields, with context fields resolved, to the wrapped core
+func (tc traceCore) With(fields []zapcore.Field) zapcore.Core {
+	return traceCore{Core: tc.Core.With(withTraceFields(fields))}
+}
+
+// Check adds the trace core to the checked entry if the entry level is enabled
+func (tc traceCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if tc.Enabled(entry.Level) {
+		return checked.AddCore(entry, tc)
+	}
+	return checked
+}
+
+// Write writes the entry, with context fields resolved, to the wrapped core
+func (tc traceCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	return tc.Core.Write(entry, withTraceFields(fields))
+}
+
+// withTraceFields returns the given fields with every context field replaced by the trace
+// correlation fields of the span on the context
+func withTraceFields(fields []zapcore.Field) []zapcore.Field {
+	var resolved []zapcore.Field
+	for i, field := range fields {
+		ctx, ok := field.Interface.(context.Context)
+		if field.Type != zapcore.SkipType || !ok {
+			if resolved != nil {
+				resolved = append(resolved, field)
+			}
+			continue
+		}
+		if resolved == nil {
+			resolved = append(make([]zapcore.Field, 0, len(fields)+2), fields[:i]...)
+		}
+		resolved = append(resolved, SpanLogFields(ctx)...)
+	}
+	if resolved == nil {
+		return fields
+	}
+	return resolved
+}
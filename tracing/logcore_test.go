@@ -0,0 +1,114 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewTraceCore(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	span, spanCtx := opentracing.StartSpanFromContextWithTracer(context.Background(), tracer, "test")
+	defer span.Finish()
+	sc := span.Context().(jaeger.SpanContext)
+
+	tests := []struct {
+		name         string
+		log          func(logger *zap.Logger)
+		expectFields map[string]interface{}
+	}{
+		{
+			"context fields are replaced with trace fields",
+			func(logger *zap.Logger) { logger.Info("msg", zap.String("key", "value"), ContextField(spanCtx)) },
+			map[string]interface{}{"key": "value", "trace_id": sc.TraceID().String(), "span_id": sc.SpanID().String(), "sampled": true},
+		}, {
+			"context fields added with With are replaced with trace fields",
+			func(logger *zap.Logger) { logger.With(ContextField(spanCtx)).Info("msg", zap.String("key", "value")) },
+			map[string]interface{}{"key": "value", "trace_id": sc.TraceID().String(), "span_id": sc.SpanID().String(), "sampled": true},
+		}, {
+			"contexts without spans add no fields",
+			func(logger *zap.Logger) {
+				logger.Info("msg", zap.String("key", "value"), ContextField(context.Background()))
+			},
+			map[string]interface{}{"key": "value"},
+		}, {
+			"logs without context fields are unchanged",
+			func(logger *zap.Logger) { logger.Info("msg", zap.String("key", "value")) },
+			map[string]interface{}{"key": "value"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, recordedLogs := observer.New(zapcore.InfoLevel)
+			test.log(zap.New(NewTraceCore(core)))
+			logs := recordedLogs.All()
+			require.Len(t, logs, 1)
+			assert.Equal(t, test.expectFields, logs[0].ContextMap())
+		})
+	}
+}
+
+func TestNewTraceCoreLevel(t *testing.T) {
+	core, recordedLogs := observer.New(zapcore.InfoLevel)
+	zap.New(NewTraceCore(core)).Debug("msg", ContextField(context.Background()))
+	assert.Equal(t, 0, recordedLogs.Len())
+}
+
+func TestNewTraceCoreSampling(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	span, spanCtx := opentracing.StartSpanFromContextWithTracer(context.Background(), tracer, "test")
+	defer span.Finish()
+
+	core, recordedLogs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(zapcore.NewSampler(NewTraceCore(core), time.Minute, 1, 100))
+	for i := 0; i < 3; i++ {
+		logger.Info("msg", ContextField(spanCtx))
+	}
+	logs := recordedLogs.All()
+	require.Len(t, logs, 1)
+	assert.Equal(t, span.Context().(jaeger.SpanContext).TraceID().String(), logs[0].ContextMap()["trace_id"])
+}
+
+func TestNewTraceCoreTeeLevels(t *testing.T) {
+	infoCore, infoLogs := observer.New(zapcore.InfoLevel)
+	debugCore, debugLogs := observer.New(zapcore.DebugLevel)
+	zap.New(zapcore.NewTee(NewTraceCore(infoCore), NewTraceCore(debugCore))).Debug("msg", ContextField(context.Background()))
+	assert.Equal(t, 0, infoLogs.Len())
+	assert.Equal(t, 1, debugLogs.Len())
+}
+
+// failingWriter is a zapcore.WriteSyncer whose writes always fail
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, assert.AnError }
+func (failingWriter) Sync() error               { return nil }
+
+func TestNewTraceCoreWriteError(t *testing.T) {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), failingWriter{}, zapcore.InfoLevel)
+	err := NewTraceCore(core).Write(zapcore.Entry{Level: zapcore.InfoLevel}, []zapcore.Field{ContextField(context.Background())})
+	assert.Error(t, err)
+}
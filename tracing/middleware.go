@@ -15,11 +15,26 @@
 package tracing
 
 import (
+	"bufio"
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	dbsql "database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cep21/circuit/v3"
 	"github.com/opentracing/opentracing-go"
@@ -27,13 +42,17 @@ import (
 	"github.com/spothero/tools/http/writer"
 	"github.com/spothero/tools/log"
 	sql "github.com/spothero/tools/sql/middleware"
+	"github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
 )
 
-// setSpanTags sets default HTTP span tags
-func setSpanTags(r *http.Request, span opentracing.Span) opentracing.Span {
+// setSpanTags sets default HTTP span tags. The http.url and http.path tags are set to the given
+// URL and path, which may be redacted or normalized, since tags cannot be replaced once set on a
+// jaeger span.
+func setSpanTags(r *http.Request, span opentracing.Span, url, path string) opentracing.Span {
 	span = span.SetTag("http.method", r.Method)
-	span = span.SetTag("http.url", r.URL.String())
-	span = span.SetTag("http.path", writer.FetchRoutePathTemplate(r))
+	span = span.SetTag("http.url", url)
+	span = span.SetTag("http.path", path)
 	span = span.SetTag("http.user_agent", r.UserAgent())
 	if contentLengthStr := r.Header.Get("Content-Length"); len(contentLengthStr) > 0 {
 		if contentLength, err := strconv.Atoi(contentLengthStr); err == nil {
@@ -43,48 +62,939 @@ func setSpanTags(r *http.Request, span opentracing.Span) opentracing.Span {
 	return span
 }
 
+// middlewareOptions contains the configuration for the tracing HTTP server middleware
+type middlewareOptions struct {
+	tracer            opentracing.Tracer
+	slowSpanThreshold time.Duration
+	now               func() time.Time
+	random            func() float64
+	samplingRules     []SamplingRule
+	methodOperation   bool
+	sanitizeOperation OperationSanitizer
+	queryParam        string
+	countRequestBody  bool
+	checkBodyOrder    bool
+	spanLimit         int
+	idempotencyHeader string
+	samplingSchedule  []SamplingWindow
+	logsOnlyHeader    string
+	samplingHints     bool
+	headerSampling    []HeaderSamplingRule
+	userSampling      bool
+	userSamplingRate  float64
+	queueTimeHeader   string
+	logSpanID         bool
+	cacheHeader       string
+	cacheTTL          bool
+	clientErrors      bool
+	nonErrorStatuses  []int
+	shouldTrace       func(ctx context.Context) bool
+	samplerTags       opentracing.Tags
+	redactedParams    []string
+	pathPatterns      []PathSegmentPattern
+	largeRequestSize  int64
+	requestIDHeader   string
+	startOptions      []opentracing.StartSpanOption
+	version           string
+	minDuration       time.Duration
+	userCtxKey        interface{}
+	experimentCtxKey  interface{}
+	tagHandler        bool
+	progressInterval  time.Duration
+	classifyError     ErrorClassifier
+	tenantHeader      string
+	overrides         *SamplingOverrides
+	errorRate         *errorRateTracker
+	depthSampling     *DepthSampling
+}
+
+// newDefaultMiddlewareOptions returns the default tracing HTTP server middleware configuration
+func newDefaultMiddlewareOptions() middlewareOptions {
+	return middlewareOptions{
+		now:           time.Now,
+		random:        rand.Float64,
+		classifyError: ClassifyError,
+	}
+}
+
+// MiddlewareOption is a function that adds configuration to the tracing HTTP server middleware
+type MiddlewareOption func(*middlewareOptions)
+
+// WithSlowSpanThreshold enables a warning log for every HTTP request whose span lasts longer
+// than the given threshold. The log includes the route, status code, and duration of the
+// request. Disabled by default.
+func WithSlowSpanThreshold(threshold time.Duration) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.slowSpanThreshold = threshold
+	}
+}
+
+// WithSamplingRules sets the route sampling rules evaluated on every HTTP request. Rules are
+// evaluated in order and the first rule whose pattern matches the route determines the sampling
+// decision of the request span. Requests which match no rule use the tracer's sampler. Rules are
+// not evaluated for requests continuing an upstream trace, which keep the upstream decision.
+func WithSamplingRules(rules ...SamplingRule) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.samplingRules = rules
+	}
+}
+
+// WithMethodOperationName prefixes the span operation name with the HTTP method of the request,
+// for example "POST /orders", instead of using the bare route path template. Defaults to false.
+func WithMethodOperationName(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.methodOperation = enabled
+	}
+}
+
+// OperationSanitizer rewrites span operation names, for example to enforce naming rules or to
+// collapse high-cardinality path segments
+type OperationSanitizer func(operationName string) string
+
+// WithOperationSanitizer applies the given sanitizer to every span operation name produced by the
+// middleware, after the route path template and, if enabled, the HTTP method are applied. This
+// allows platform teams to enforce low-cardinality operation names centrally. By default,
+// operation names are not modified.
+func WithOperationSanitizer(sanitizer OperationSanitizer) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.sanitizeOperation = sanitizer
+	}
+}
+
+// operationName returns the span operation name for the given request
+func (o middlewareOptions) operationName(r *http.Request) string {
+	operationName := writer.FetchRoutePathTemplate(r)
+	if o.methodOperation {
+		operationName = fmt.Sprintf("%s %s", r.Method, operationName)
+	}
+	if o.sanitizeOperation != nil {
+		operationName = o.sanitizeOperation(operationName)
+	}
+	return operationName
+}
+
+// WithQueryParamExtraction enables extraction of the span context from the given query parameter
+// when no span context is present in the request headers. The query parameter must contain a
+// base64-encoded text map, as produced by InjectQueryParam. Disabled by default.
+func WithQueryParamExtraction(param string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.queryParam = param
+	}
+}
+
+// WithRequestBodySize tags the span with the number of request body bytes read by the handler as
+// http.request_size. Unlike http.content_length, this tag is accurate for chunked requests. The
+// body is never buffered; bytes are only counted as the handler consumes them. Defaults to false.
+func WithRequestBodySize(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.countRequestBody = enabled
+	}
+}
+
+// WithBodyOrderingCheck is a debugging aid for middleware ordering mistakes. When enabled along
+// with WithRequestBodySize, a warning is logged if the request body appears to have been consumed
+// before the tracing middleware, that is, if the body ended before Content-Length bytes were read.
+// Defaults to false.
+func WithBodyOrderingCheck(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.checkBodyOrder = enabled
+	}
+}
+
+// countingReadCloser counts the bytes read from the wrapped ReadCloser
+type countingReadCloser struct {
+	io.ReadCloser
+	count int64
+	eof   bool
+}
+
+// Read implements io.Reader, counting all bytes read from the wrapped ReadCloser
+func (crc *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := crc.ReadCloser.Read(p)
+	crc.count += int64(n)
+	if err == io.EOF {
+		crc.eof = true
+	}
+	return n, err
+}
+
+// consumedEarly returns true if the body ended before the given content length was read,
+// indicating that part of the body was read before it was wrapped
+func (crc *countingReadCloser) consumedEarly(contentLength int64) bool {
+	return crc.eof && contentLength > 0 && crc.count < contentLength
+}
+
+// WithSpanIDLogging adds the span_id of the request span to the context logger, alongside the
+// correlation_id, so that individual log lines may be correlated with a specific span. Defaults
+// to false.
+func WithSpanIDLogging(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.logSpanID = enabled
+	}
+}
+
+// embedSpanID embeds the span ID of the active span in the context logger
+func embedSpanID(ctx context.Context) context.Context {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if sc, ok := span.Context().(jaeger.SpanContext); ok {
+			ctx = log.NewContext(ctx, log.Get(ctx).With(zap.String("span_id", sc.SpanID().String())))
+		}
+	}
+	return ctx
+}
+
+// WithCacheHeader tags the span with the value of the given response header, such as X-Cache,
+// as http.cache. This allows cache hit rates to be inspected per route. The tag is omitted if the
+// response does not contain the header. Disabled by default.
+func WithCacheHeader(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.cacheHeader = header
+	}
+}
+
+// cacheTTLCtxKeyType is the type used to uniquely place the cache TTL holder in contexts
+type cacheTTLCtxKeyType int
+
+// cacheTTLCtxKey is the key into any context.Context which maps to the cache TTL holder
+const cacheTTLCtxKey cacheTTLCtxKeyType = iota
+
+// cacheTTLHolder holds the cache TTL of the response, if set
+type cacheTTLHolder struct {
+	ttl time.Duration
+	set bool
+}
+
+// WithCacheTTL tags the span with the duration for which the response will be cached, as
+// recorded by the caching layer with SetCacheTTL, as http.cache_ttl_seconds. The tag is omitted if
+// no TTL was recorded. Disabled by default.
+func WithCacheTTL(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.cacheTTL = enabled
+	}
+}
+
+// SetCacheTTL records the duration for which the response to the request will be cached on the
+// given context, for use as the http.cache_ttl_seconds tag when WithCacheTTL is enabled. This is a
+// no-op if the context did not pass through the middleware with WithCacheTTL enabled.
+func SetCacheTTL(ctx context.Context, ttl time.Duration) {
+	if holder, ok := ctx.Value(cacheTTLCtxKey).(*cacheTTLHolder); ok {
+		holder.ttl = ttl
+		holder.set = true
+	}
+}
+
+// WithErrorOnClientError tags spans as errored for 4XX responses in addition to 5XX responses.
+// Defaults to false.
+func WithErrorOnClientError(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.clientErrors = enabled
+	}
+}
+
+// WithNonErrorStatuses excludes the given response status codes, such as 501 Not Implemented for
+// endpoints which return it as a normal response, from tagging spans as errored. The error.kind
+// tag is unaffected. Disabled by default.
+func WithNonErrorStatuses(statuses ...int) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.nonErrorStatuses = statuses
+	}
+}
+
+// isErrorStatus returns true if the given response status code should mark the span as errored
+func (o middlewareOptions) isErrorStatus(statusCode int) bool {
+	for _, status := range o.nonErrorStatuses {
+		if statusCode == status {
+			return false
+		}
+	}
+	if o.clientErrors {
+		return statusCode >= http.StatusBadRequest
+	}
+	// 5XX Errors are our fault -- note that this span belongs to an errored request
+	return statusCode >= http.StatusInternalServerError
+}
+
+// WithTraceCondition sets a function, typically backed by a feature flag provider, which decides
+// per-request whether the request should be traced at all. When the function returns false, the
+// request is passed to the next handler without creating a span. All requests are traced by
+// default.
+func WithTraceCondition(shouldTrace func(ctx context.Context) bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.shouldTrace = shouldTrace
+	}
+}
+
+// WithSamplerTags tags every span with the sampler configured in the given Config, to help
+// diagnose why requests are or are not sampled. The following tags are added:
+// * sampler.type - The configured sampler type, defaulting to "const"
+// * sampler.param - The configured sampler parameter
+func WithSamplerTags(config Config) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		samplerConfig := config.jaegerConfiguration().Sampler
+		o.samplerTags = opentracing.Tags{
+			"sampler.type":  samplerConfig.Type,
+			"sampler.param": samplerConfig.Param,
+		}
+	}
+}
+
+// WithRedactedQueryParams replaces the values of the given query parameters, such as tokens or
+// API keys, with REDACTED in the http.url tag. All other query parameters are preserved as-is.
+func WithRedactedQueryParams(params ...string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.redactedParams = params
+	}
+}
+
+// redactURL returns the given URL with the values of the given query parameters redacted. The
+// order and encoding of all other query parameters is preserved.
+func redactURL(u *url.URL, params []string) string {
+	if u.RawQuery == "" || len(params) == 0 {
+		return u.String()
+	}
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		key := strings.SplitN(pair, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		for _, param := range params {
+			if key == param {
+				pairs[i] = fmt.Sprintf("%s=REDACTED", strings.SplitN(pair, "=", 2)[0])
+				break
+			}
+		}
+	}
+	redacted := *u
+	redacted.RawQuery = strings.Join(pairs, "&")
+	return redacted.String()
+}
+
+// PathSegmentPattern replaces every URL path segment fully matching the Pattern with the
+// Placeholder when normalizing span tags
+type PathSegmentPattern struct {
+	Pattern     *regexp.Regexp
+	Placeholder string
+}
+
+// DefaultPathSegmentPatterns replace numeric path segments with ":id" and UUID path segments
+// with ":uuid"
+var DefaultPathSegmentPatterns = []PathSegmentPattern{
+	{Pattern: regexp.MustCompile(`^[0-9]+$`), Placeholder: ":id"},
+	{Pattern: regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`), Placeholder: ":uuid"},
+}
+
+// WithTagNormalization normalizes the path of the http.url and http.path tags by replacing path
+// segments matching the given patterns with placeholders, for example "/users/123" becomes
+// "/users/:id". This bounds the cardinality of the tags for pipelines which ingest span tags as
+// metric labels. If no patterns are given, DefaultPathSegmentPatterns are used. Disabled by
+// default.
+func WithTagNormalization(patterns ...PathSegmentPattern) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		if len(patterns) == 0 {
+			patterns = DefaultPathSegmentPatterns
+		}
+		o.pathPatterns = patterns
+	}
+}
+
+// normalizePath replaces every segment of the given path which matches one of the given patterns
+// with the pattern placeholder
+func normalizePath(path string, patterns []PathSegmentPattern) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		for _, pattern := range patterns {
+			if pattern.Pattern.MatchString(segment) {
+				segments[i] = pattern.Placeholder
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// pathTag returns the http.path tag value for the given request, the route path template with its
+// path segments normalized as configured
+func (o middlewareOptions) pathTag(r *http.Request) string {
+	path := writer.FetchRoutePathTemplate(r)
+	if len(o.pathPatterns) > 0 {
+		path = normalizePath(path, o.pathPatterns)
+	}
+	return path
+}
+
+// urlTag returns the http.url tag value for the given request, with its path normalized and its
+// query parameters redacted as configured
+func (o middlewareOptions) urlTag(r *http.Request) string {
+	u := *r.URL
+	if len(o.pathPatterns) > 0 {
+		u.Path = normalizePath(u.Path, o.pathPatterns)
+		u.RawPath = ""
+	}
+	return redactURL(&u, o.redactedParams)
+}
+
+// WithRequestIDBaggage sets the value of the given inbound request header, such as X-Request-Id,
+// as the request_id baggage item and span tag, so that the request ID is propagated to and visible
+// in all downstream spans. Requests without the header are unaffected. Disabled by default.
+func WithRequestIDBaggage(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.requestIDHeader = header
+	}
+}
+
+// WithIdempotencyKey tags the span with a hash of the value of the given inbound request header,
+// such as Idempotency-Key, as http.idempotency_key, so that client retries of the same request may
+// be correlated. The raw key is never tagged, since idempotency keys may be guessable or carry
+// sensitive data. Requests without the header are unaffected. Disabled by default.
+func WithIdempotencyKey(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.idempotencyHeader = header
+	}
+}
+
+// hashIdempotencyKey returns the hex-encoded SHA-256 hash of the given idempotency key
+func hashIdempotencyKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// WithQueueTime tags the span with the milliseconds the request waited before reaching the
+// middleware as http.queue_time_ms, computed from the request start timestamp set by the edge
+// in the given header, such as X-Request-Start. Timestamps may be prefixed with "t=" and may be
+// seconds with a fractional part, such as "t=1600000000.123", or integer seconds, milliseconds,
+// or microseconds since the epoch. Missing or malformed timestamps, and timestamps in the future
+// due to clock skew, are ignored. Disabled by default.
+func WithQueueTime(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.queueTimeHeader = header
+	}
+}
+
+// parseRequestStart parses a request start timestamp as described by WithQueueTime
+func parseRequestStart(value string) (time.Time, bool) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "t=")
+	if i := strings.Index(value, "."); i >= 0 {
+		// The fraction is parsed separately to avoid floating point rounding
+		seconds, err := strconv.ParseInt(value[:i], 10, 64)
+		if err != nil || seconds <= 0 {
+			return time.Time{}, false
+		}
+		fraction := value[i+1:]
+		if len(fraction) > 9 {
+			fraction = fraction[:9]
+		}
+		nanos, err := strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+		if err != nil || nanos < 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(seconds, nanos), true
+	}
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || timestamp <= 0 {
+		return time.Time{}, false
+	}
+	switch {
+	case timestamp > 1e15:
+		return time.Unix(0, timestamp*int64(time.Microsecond)), true
+	case timestamp > 1e12:
+		return time.Unix(0, timestamp*int64(time.Millisecond)), true
+	}
+	return time.Unix(timestamp, 0), true
+}
+
+// WithVersion tags every request span with the given application version as version when the
+// span is started, so that spans of different versions, such as during a canary rollout, may be
+// compared. The tag is omitted if the version is empty.
+func WithVersion(version string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.version = version
+	}
+}
+
+// WithStartSpanOptions applies the given options when starting every request span, after the
+// options set by the middleware. This allows, for example, setting the start time of replayed or
+// backfilled requests with opentracing.StartTime.
+func WithStartSpanOptions(opts ...opentracing.StartSpanOption) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.startOptions = opts
+	}
+}
+
+// WithRequestSpanLimit limits the number of SQL spans started under each request span to the
+// given limit, see WithSpanLimit. Request spans which reach the limit are tagged with
+// spans.truncated set to true. Disabled by default.
+func WithRequestSpanLimit(limit int) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.spanLimit = limit
+	}
+}
+
+// WithMinimumSpanDuration drops the spans of successful requests which complete faster than the
+// given duration, reducing the noise of trivial requests. Since spans cannot be discarded once
+// reported, dropped spans are abandoned rather than finished, so they are never reported. Note
+// that spans already finished by the handler, such as SQL spans, are still reported and will
+// reference the missing request span as their parent. Spans of errored requests are never
+// dropped. Disabled by default.
+func WithMinimumSpanDuration(minDuration time.Duration) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.minDuration = minDuration
+	}
+}
+
+// WithUserContextKey tags every request span with the user ID stored in the request context under
+// the given key, typically by an authentication middleware which runs before this middleware. The
+// context value must be a string and is tagged as described by TagUser. Disabled by default.
+func WithUserContextKey(key interface{}) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.userCtxKey = key
+	}
+}
+
+// WithExperimentContextKey tags every request span with the feature experiment variants stored in
+// the request context under the given key, typically by an experimentation middleware which runs
+// before this middleware. The context value must be a map[string]string of experiment names to
+// assigned variants, each of which is tagged as described by TagExperiment. Disabled by default.
+func WithExperimentContextKey(key interface{}) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.experimentCtxKey = key
+	}
+}
+
+// WithHandlerName tags every request span with the name of the handler which served the request
+// as http.handler. The handler is resolved from the matched gorilla mux route, or may be recorded
+// by handlers wrapped with NamedHandler or calling SetHandlerName. The tag is omitted if the
+// handler could not be resolved. Defaults to false.
+func WithHandlerName(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.tagHandler = enabled
+	}
+}
+
+// WithResponseProgress logs the number of response bytes written so far on the span as a
+// "response progress" event, at most once per the given interval, and tags the span with the
+// total as http.response_size when the response completes. This helps debug stalled streaming
+// responses. Requires writer.StatusRecorderMiddleware. Disabled by default.
+func WithResponseProgress(interval time.Duration) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.progressInterval = interval
+	}
+}
+
+// ErrorClassifier returns the kind of error of a request, tagged as error.kind, given the
+// response status code and the error of the request context, if any. An empty string indicates
+// that the request did not error. The status code is 0 if it could not be determined.
+type ErrorClassifier func(statusCode int, ctxErr error) string
+
+// ClassifyError is the default ErrorClassifier. Requests are classified as follows:
+// * timeout - The request context deadline was exceeded
+// * canceled - The request context was canceled, typically by the client disconnecting
+// * server - The response status code is 5XX
+// * client - The response status code is 4XX
+func ClassifyError(statusCode int, ctxErr error) string {
+	switch {
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(ctxErr, context.Canceled):
+		return "canceled"
+	case statusCode >= http.StatusInternalServerError:
+		return "server"
+	case statusCode >= http.StatusBadRequest:
+		return "client"
+	}
+	return ""
+}
+
+// WithErrorClassifier overrides the function used to classify request errors for the error.kind
+// tag. Defaults to ClassifyError.
+func WithErrorClassifier(classifier ErrorClassifier) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.classifyError = classifier
+	}
+}
+
+// WithClock sets the clock used to time requests, including the start and finish times of request
+// spans. This allows tests to produce deterministic span durations. Defaults to time.Now.
+func WithClock(now func() time.Time) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.now = now
+	}
+}
+
+// WithTenantHeader threads the tenant identified by the given inbound request header through
+// traces and logs. The tenant is set as the tenant_id baggage item, so that it is propagated to
+// downstream services, tagged on the span as tenant.id, and added to the context logger as
+// tenant_id. Requests without the header are unaffected. Disabled by default.
+func WithTenantHeader(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.tenantHeader = header
+	}
+}
+
+// WithTracer sets the OpenTracing Tracer used by the middleware. Defaults to the OpenTracing
+// global tracer at the time of each request.
+func WithTracer(tracer opentracing.Tracer) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithLogsOnlyFallback provides request correlation for services which do not run a tracing
+// backend. When the middleware tracer is a no-op tracer, as configured when tracing is disabled,
+// no span is created. Instead, a random UUID correlation ID is generated for every request, added
+// to the context logger as correlation_id, made available through GetCorrelationID, and set on
+// the response as the given header, such as X-Correlation-Id. Disabled by default.
+func WithLogsOnlyFallback(header string) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.logsOnlyHeader = header
+	}
+}
+
+// newCorrelationID returns a random version 4 UUID for use as a correlation ID
+func newCorrelationID() (string, error) {
+	var id [16]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		return "", err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]), nil
+}
+
+// isNoopTracer returns true if the given tracer is a no-op tracer, as configured when tracing is
+// disabled
+func isNoopTracer(tracer opentracing.Tracer) bool {
+	switch tracer.(type) {
+	case opentracing.NoopTracer, *opentracing.NoopTracer:
+		return true
+	}
+	return false
+}
+
+// serveLogsOnly serves the request without a span, correlating its logs with a generated
+// correlation ID. False is returned if no correlation ID could be generated.
+func (o middlewareOptions) serveLogsOnly(next http.Handler, w http.ResponseWriter, r *http.Request) bool {
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return false
+	}
+	ctx := log.NewContext(r.Context(), log.Get(r.Context()).With(zap.String("correlation_id", correlationID)))
+	ctx = context.WithValue(ctx, CorrelationIDCtxKey, correlationID)
+	w.Header().Set(o.logsOnlyHeader, correlationID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+	return true
+}
+
+// getTracer returns the configured Tracer, or the OpenTracing global tracer if none was set
+func (o middlewareOptions) getTracer() opentracing.Tracer {
+	if o.tracer == nil {
+		return opentracing.GlobalTracer()
+	}
+	return o.tracer
+}
+
+// NewHTTPServerMiddleware returns the tracing HTTP server middleware configured with the given
+// options. See HTTPServerMiddleware for details on the behavior of the middleware.
+func NewHTTPServerMiddleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	options := newDefaultMiddlewareOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if options.shouldTrace != nil && !options.shouldTrace(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if options.logsOnlyHeader != "" {
+				if isNoopTracer(options.getTracer()) && options.serveLogsOnly(next, w, r) {
+					return
+				}
+			}
+			startTime := options.now()
+			logger := log.Get(r.Context())
+			tracer := tracerForContext(r.Context(), options.getTracer())
+			wireContext, err := tracer.Extract(
+				opentracing.HTTPHeaders,
+				opentracing.HTTPHeadersCarrier(r.Header))
+			if err != nil && options.queryParam != "" {
+				wireContext, err = extractQueryParam(tracer, r, options.queryParam)
+			}
+			if err != nil {
+				logger.Debug("failed to extract opentracing context on an incoming http request")
+			}
+			startOptions := []opentracing.StartSpanOption{ext.RPCServerOption(wireContext), opentracing.StartTime(startTime)}
+			// Local sampling decisions never override the decision of an upstream service
+			forceSampled := false
+			if !hasUpstreamSamplingDecision(wireContext) {
+				if samplingPriority, ok := options.samplingPriority(r); ok {
+					startOptions = append(startOptions, samplingPriority)
+					forceSampled = samplingPriority.Value == uint16(1)
+				}
+			}
+			depth := 0
+			if options.depthSampling != nil {
+				depth = traceDepth(wireContext)
+				if samplingPriority, ok := options.depthSamplingPriority(r, wireContext, depth); ok {
+					startOptions = append(startOptions, samplingPriority)
+				}
+			}
+			if options.samplingHints && !forceSampled && hasSamplingHint(wireContext) {
+				startOptions = append(startOptions, opentracing.Tag{Key: string(ext.SamplingPriority), Value: uint16(1)})
+				forceSampled = true
+			}
+			if options.samplerTags != nil {
+				startOptions = append(startOptions, options.samplerTags)
+			}
+			if options.version != "" {
+				startOptions = append(startOptions, opentracing.Tag{Key: "version", Value: options.version})
+			}
+			startOptions = append(startOptions, options.startOptions...)
+			span, spanCtx := opentracing.StartSpanFromContextWithTracer(r.Context(), tracer, options.operationName(r), startOptions...)
+			span = setSpanTags(r, span, options.urlTag(r), options.pathTag(r))
+			if options.samplingHints && forceSampled {
+				span = span.SetBaggageItem(samplingHintBaggageKey, "1")
+			}
+			if options.depthSampling != nil {
+				span = span.SetBaggageItem(traceDepthBaggageKey, strconv.Itoa(depth))
+			}
+			// The route is tagged regardless of the operation name so that spans may always be
+			// filtered by route
+			if route := writer.FetchRoutePathTemplate(r); route != "" {
+				span = span.SetTag("http.route", route)
+			}
+			if r.Host != "" {
+				span = span.SetTag("http.host", r.Host)
+			}
+			upgrade := upgradeProtocol(r)
+			if upgrade != "" {
+				span = span.SetTag("http.upgrade", upgrade)
+			}
+			if options.requestIDHeader != "" {
+				if requestID := r.Header.Get(options.requestIDHeader); requestID != "" {
+					span = span.SetBaggageItem("request_id", requestID).SetTag("request_id", requestID)
+				}
+			}
+			if options.queueTimeHeader != "" {
+				if requestStart, ok := parseRequestStart(r.Header.Get(options.queueTimeHeader)); ok && !requestStart.After(startTime) {
+					span = span.SetTag("http.queue_time_ms", startTime.Sub(requestStart).Milliseconds())
+				}
+			}
+			if options.idempotencyHeader != "" {
+				if key := r.Header.Get(options.idempotencyHeader); key != "" {
+					span = span.SetTag("http.idempotency_key", hashIdempotencyKey(key))
+				}
+			}
+			tenantID := ""
+			if options.tenantHeader != "" {
+				if tenantID = r.Header.Get(options.tenantHeader); tenantID != "" {
+					span = span.SetBaggageItem("tenant_id", tenantID).SetTag("tenant.id", tenantID)
+				}
+			}
+			var bodyCounter *countingReadCloser
+			var handler string
+			var cacheTTL cacheTTLHolder
+			defer func() {
+				if handler == "" && options.tagHandler {
+					handler = routeHandlerName(r)
+				}
+				if handler != "" {
+					span = span.SetTag("http.handler", handler)
+				}
+				if bodyCounter != nil {
+					span = span.SetTag("http.request_size", bodyCounter.count)
+					if options.checkBodyOrder && bodyCounter.consumedEarly(r.ContentLength) {
+						logger.Warn(
+							"http request body was consumed before the tracing middleware",
+							zap.Int64("http.content_length", r.ContentLength),
+							zap.Int64("http.request_size", bodyCounter.count),
+						)
+					}
+				}
+				if cacheTTL.set {
+					span = span.SetTag("http.cache_ttl_seconds", int64(cacheTTL.ttl/time.Second))
+				}
+				if options.cacheHeader != "" {
+					if cacheStatus := w.Header().Get(options.cacheHeader); cacheStatus != "" {
+						span = span.SetTag("http.cache", cacheStatus)
+					}
+				}
+				statusCode := 0
+				errored := false
+				if statusRecorder, ok := w.(*writer.StatusRecorder); ok {
+					if options.progressInterval > 0 {
+						span = span.SetTag("http.response_size", statusRecorder.BytesWritten)
+					}
+					statusCode = statusRecorder.Status()
+					span = span.SetTag("http.status_code", strconv.Itoa(statusCode))
+					if statusCode == http.StatusNotModified && isConditionalRequest(r) {
+						span = span.SetTag("http.conditional", true)
+					}
+					if options.isErrorStatus(statusCode) {
+						errored = true
+						span = span.SetTag("error", true)
+					}
+				}
+				if errorKind := options.classifyError(statusCode, r.Context().Err()); errorKind != "" {
+					span = span.SetTag("error.kind", errorKind)
+				}
+				finishTime := options.now()
+				if options.errorRate != nil && statusCode != 0 {
+					options.errorRate.record(finishTime, errored)
+				}
+				duration := finishTime.Sub(startTime)
+				if options.slowSpanThreshold > 0 && duration > options.slowSpanThreshold {
+					logger.Warn(
+						"slow http request",
+						zap.String("http.path", writer.FetchRoutePathTemplate(r)),
+						zap.Int("http.status_code", statusCode),
+						zap.Duration("http.duration", duration),
+					)
+				}
+				if duration < options.minDuration && !errored {
+					// Abandon the span so that it is never reported
+					return
+				}
+				finishSpan(span, opentracing.FinishOptions{FinishTime: finishTime})
+			}()
+			if options.userCtxKey != nil {
+				if userID, ok := r.Context().Value(options.userCtxKey).(string); ok {
+					TagUser(spanCtx, userID)
+				}
+			}
+			if options.experimentCtxKey != nil {
+				if variants, ok := r.Context().Value(options.experimentCtxKey).(map[string]string); ok {
+					for experiment, variant := range variants {
+						TagExperiment(spanCtx, experiment, variant)
+					}
+				}
+			}
+			spanCtx = EmbedCorrelationID(spanCtx)
+			if tenantID != "" {
+				spanCtx = log.NewContext(spanCtx, log.Get(spanCtx).With(zap.String("tenant_id", tenantID)))
+			}
+			if options.logSpanID {
+				spanCtx = embedSpanID(spanCtx)
+			}
+			// Jaeger force-samples requests carrying a debug ID, so make the debug ID searchable in logs
+			if debugID := r.Header.Get(jaeger.JaegerDebugHeader); debugID != "" {
+				spanCtx = log.NewContext(spanCtx, log.Get(spanCtx).With(zap.String("jaeger_debug_id", debugID)))
+			}
+			if options.tagHandler {
+				spanCtx = context.WithValue(spanCtx, handlerNameCtxKey, &handler)
+			}
+			if options.cacheTTL {
+				spanCtx = context.WithValue(spanCtx, cacheTTLCtxKey, &cacheTTL)
+			}
+			if statusRecorder, ok := w.(*writer.StatusRecorder); ok && options.progressInterval > 0 {
+				onWrite := statusRecorder.OnWrite
+				lastProgress := startTime
+				statusRecorder.OnWrite = func(bytesWritten int64) {
+					if onWrite != nil {
+						onWrite(bytesWritten)
+					}
+					if now := options.now(); now.Sub(lastProgress) >= options.progressInterval {
+						lastProgress = now
+						span.LogKV("event", "response progress", "http.response_size", bytesWritten)
+					}
+				}
+				defer func() { statusRecorder.OnWrite = onWrite }()
+			}
+			spanCtx = WithSpanLimit(spanCtx, options.spanLimit)
+			tracedRequest := r.WithContext(spanCtx)
+			if options.countRequestBody && r.Body != nil {
+				bodyCounter = &countingReadCloser{ReadCloser: r.Body}
+				tracedRequest.Body = bodyCounter
+			}
+			var responseWriter http.ResponseWriter = w
+			if hijacker, ok := w.(http.Hijacker); ok && upgrade != "" {
+				responseWriter = upgradeResponseWriter{ResponseWriter: w, hijacker: hijacker, span: span}
+			}
+			next.ServeHTTP(responseWriter, tracedRequest)
+		})
+	}
+}
+
+// isConditionalRequest returns true if the request carries cache validators, so that the server
+// may answer with 304 Not Modified instead of the full response
+func isConditionalRequest(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// upgradeProtocol returns the lowercased protocol to which the request asks to upgrade the
+// connection, such as websocket, or an empty string if the request is not an upgrade request
+func upgradeProtocol(r *http.Request) string {
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return strings.ToLower(r.Header.Get("Upgrade"))
+			}
+		}
+	}
+	return ""
+}
+
+// upgradeResponseWriter logs an event on the span when the connection is hijacked to complete a
+// protocol upgrade
+type upgradeResponseWriter struct {
+	http.ResponseWriter
+	hijacker http.Hijacker
+	span     opentracing.Span
+}
+
+// Hijack implements http.Hijacker, logging the upgrade on the span once the connection is hijacked
+func (urw upgradeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := urw.hijacker.Hijack()
+	if err == nil {
+		urw.span.LogKV("event", "connection upgraded")
+	}
+	return conn, rw, err
+}
+
 // HTTPServerMiddleware extracts the OpenTracing context on all incoming HTTP requests, if present. if
 // no trace ID is present in the headers, a trace is initiated.
 //
 // The following tags are placed on all incoming HTTP requests:
 // * http.method
 // * http.url
+// * http.route (the route path template, if the request was routed)
+// * http.host (the Host header of the request, which may differ from the URL host)
+// * http.upgrade (the protocol requested by upgrade requests, such as websocket)
+//
+// Spans of upgrade requests, such as websockets, cover the lifetime of the upgraded connection.
+// The time of the upgrade is logged on the span as the "connection upgraded" event.
+//
+// Requests continuing an upstream trace always honor the upstream sampling decision. Requests
+// carrying a jaeger-debug-id header are always sampled and the debug ID is added to the
+// context logger as jaeger_debug_id.
 //
 // Outbound responses will be tagged with the following tags, if applicable:
 // * http.status_code
 // * error (if the status code is >= 500)
+// * error.kind (client, server, timeout, or canceled, see ClassifyError)
+// * http.conditional (true if a conditional request was answered with 304 Not Modified)
 //
 // The returned HTTP Request includes the wrapped OpenTracing Span Context.
 // Note that this middleware must be attached after writer.StatusRecorderMiddleware
 // for HTTP response span tagging to function.
 func HTTPServerMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger := log.Get(r.Context())
-		wireContext, err := opentracing.GlobalTracer().Extract(
-			opentracing.HTTPHeaders,
-			opentracing.HTTPHeadersCarrier(r.Header))
-		if err != nil {
-			logger.Debug("failed to extract opentracing context on an incoming http request")
-		}
-		span, spanCtx := opentracing.StartSpanFromContext(r.Context(), writer.FetchRoutePathTemplate(r), ext.RPCServerOption(wireContext))
-		span = setSpanTags(r, span)
-		defer func() {
-			if statusRecorder, ok := w.(*writer.StatusRecorder); ok {
-				span = span.SetTag("http.status_code", strconv.Itoa(statusRecorder.StatusCode))
-				// 5XX Errors are our fault -- note that this span belongs to an errored request
-				if statusRecorder.StatusCode >= http.StatusInternalServerError {
-					span = span.SetTag("error", true)
-				}
-			}
-			span.Finish()
-		}()
-		next.ServeHTTP(w, r.WithContext(EmbedCorrelationID(spanCtx)))
-	})
+	return NewHTTPServerMiddleware()(next)
 }
 
-// RoundTripper provides a proxied HTTP RoundTripper which traces client HTTP request details
+// RoundTripper provides a proxied HTTP RoundTripper which traces client HTTP request details.
+// If HTTPTrace is set, requests are also instrumented with net/http/httptrace and the client span
+// is tagged with http.ttfb_ms, the milliseconds elapsed until the first response byte was read.
+// If PeerIP is set, the client span is tagged with the IP address of the backend instance the
+// request was sent to, as peer.ipv4 or peer.ipv6, so that a bad instance behind a load-balanced
+// hostname may be identified.
+//
+// Client spans are started with the tracer of the span on the request context. If Tracer is set,
+// it is used for requests whose context carries no span instead of the OpenTracing global tracer.
 type RoundTripper struct {
 	RoundTripper http.RoundTripper
+	Tracer       opentracing.Tracer
+	HTTPTrace    bool
+	PeerIP       bool
 }
 
 // RoundTrip completes HTTP roundtrips while tracing HTTP request details
@@ -94,11 +1004,41 @@ func (rt RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 		panic("no roundtripper provided to tracing round tripper")
 	}
 
+	ctx := r.Context()
+	if rt.Tracer != nil {
+		ctx = ContextWithTracer(ctx, rt.Tracer)
+	}
 	operationName := fmt.Sprintf("%s %s", r.Method, r.URL.String())
-	span, spanCtx := opentracing.StartSpanFromContext(r.Context(), operationName)
-	span = setSpanTags(r, span)
+	span, spanCtx := StartSpanFromContext(ctx, operationName)
+	span = setSpanTags(r, span, r.URL.String(), writer.FetchRoutePathTemplate(r))
 
-	resp, err := rt.RoundTripper.RoundTrip(r.WithContext(EmbedCorrelationID(spanCtx)))
+	spanCtx = EmbedCorrelationID(spanCtx)
+	var firstByte time.Time
+	var peerIP net.IP
+	if rt.HTTPTrace || rt.PeerIP {
+		clientTrace := &httptrace.ClientTrace{}
+		if rt.HTTPTrace {
+			clientTrace.GotFirstResponseByte = func() { firstByte = time.Now() }
+		}
+		if rt.PeerIP {
+			clientTrace.GotConn = func(info httptrace.GotConnInfo) {
+				if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+					peerIP = net.ParseIP(host)
+				}
+			}
+		}
+		spanCtx = httptrace.WithClientTrace(spanCtx, clientTrace)
+	}
+	start := time.Now()
+	resp, err := rt.RoundTripper.RoundTrip(r.WithContext(spanCtx))
+	if !firstByte.IsZero() {
+		span = span.SetTag("http.ttfb_ms", firstByte.Sub(start).Milliseconds())
+	}
+	if peerIP.To4() != nil {
+		span = span.SetTag(string(ext.PeerHostIPv4), peerIP.String())
+	} else if peerIP != nil {
+		span = span.SetTag(string(ext.PeerHostIPv6), peerIP.String())
+	}
 	if err != nil {
 		var circuitError circuit.Error
 		if errors.As(err, &circuitError) {
@@ -116,6 +1056,30 @@ func (rt RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	return resp, err
 }
 
+// NewHTTPClient returns a copy of the given HTTP client whose transport is wrapped in the tracing
+// RoundTripper, so that all requests made through the client produce client spans. The timeout,
+// redirect policy, and cookie jar of the base client are preserved and the base client is not
+// modified. If base is nil, a client equivalent to the net/http DefaultClient is used. If the base
+// client has no transport, the net/http DefaultTransport is traced.
+func NewHTTPClient(base *http.Client) *http.Client {
+	return newHTTPClient(base, nil)
+}
+
+// newHTTPClient returns a copy of the given HTTP client traced with the given tracer, or the
+// tracer of the span on each request context if nil
+func newHTTPClient(base *http.Client, tracer opentracing.Tracer) *http.Client {
+	client := &http.Client{}
+	if base != nil {
+		*client = *base
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = RoundTripper{RoundTripper: transport, Tracer: tracer}
+	return client
+}
+
 // GetCorrelationID returns the correlation ID associated with the given
 // Context. This function only produces meaningful results for Contexts
 // associated with gRPC or HTTP Requests which have passed through
@@ -128,33 +1092,342 @@ func GetCorrelationID(ctx context.Context) string {
 	}
 }
 
+// sqlRetryCtxKeyType is the type used to uniquely place the SQL retry count in contexts
+type sqlRetryCtxKeyType int
+
+// sqlRetryCtxKey is the key into any context.Context which maps to the retry count of a SQL query
+const sqlRetryCtxKey sqlRetryCtxKeyType = iota
+
+// WithSQLRetryCount returns a context which tags SQL spans started from it with db.retry_count
+// set to the given number of retries. Database layers that retry queries, for example on
+// serialization failures, should set the retry count on the context of every retried attempt.
+// Spans started from contexts without a retry count omit the tag.
+func WithSQLRetryCount(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, sqlRetryCtxKey, retries)
+}
+
+// sqlMiddlewareOptions contains the configuration for the tracing SQL middleware
+type sqlMiddlewareOptions struct {
+	tracer         opentracing.Tracer
+	version        string
+	dbStats        func() dbsql.DBStats
+	tagCaller      bool
+	skippedQueries map[string]bool
+	driver         string
+	explain        ExplainFunc
+	explainAfter   time.Duration
+	now            func() time.Time
+}
+
+// SQLMiddlewareOption is a function that adds configuration to the tracing SQL middleware
+type SQLMiddlewareOption func(*sqlMiddlewareOptions)
+
+// WithSQLTracer sets the OpenTracing Tracer used for queries whose context carries no span.
+// Queries whose context carries a span always use the tracer of that span. Defaults to the
+// OpenTracing global tracer.
+func WithSQLTracer(tracer opentracing.Tracer) SQLMiddlewareOption {
+	return func(o *sqlMiddlewareOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithDBStats tags every SQL span with the connection pool statistics returned by the given
+// function at query time, typically the Stats method of the sql.DB. The following tags are added:
+// * db.pool.in_use - The number of connections currently in use
+// * db.pool.wait_count - The total number of connections waited for
+func WithDBStats(dbStats func() dbsql.DBStats) SQLMiddlewareOption {
+	return func(o *sqlMiddlewareOptions) {
+		o.dbStats = dbStats
+	}
+}
+
+// WithCallerTag tags every SQL span with the file:line of the code which issued the query as
+// db.caller. Frames within database/sql, sqlx, sqlhooks, and this library are skipped. Note that
+// capturing the caller has a runtime cost on every query. Defaults to false.
+func WithCallerTag(enabled bool) SQLMiddlewareOption {
+	return func(o *sqlMiddlewareOptions) {
+		o.tagCaller = enabled
+	}
+}
+
+// WithDriverName tags every SQL span with the given database driver name, such as "pgx" or
+// "postgres", as db.driver. The tag is omitted if the driver name is empty.
+func WithDriverName(driver string) SQLMiddlewareOption {
+	return func(o *sqlMiddlewareOptions) {
+		o.driver = driver
+	}
+}
+
+// WithSQLVersion tags every SQL span with the given application version as version when the span
+// is started. The tag is omitted if the version is empty.
+func WithSQLVersion(version string) SQLMiddlewareOption {
+	return func(o *sqlMiddlewareOptions) {
+		o.version = version
+	}
+}
+
+// ExplainFunc returns the query plan of the given query, typically by running EXPLAIN with the
+// same arguments. The context is the context of the traced query.
+type ExplainFunc func(ctx context.Context, query string, args ...interface{}) (string, error)
+
+// maxExplainLength is the maximum length of query plans logged on SQL spans
+const maxExplainLength = 4096
+
+// WithExplain calls the given function for every successful query slower than the given
+// threshold and logs the returned query plan on the span as db.plan, truncated to 4096 bytes.
+// Since explaining a query issues another query, the threshold should be set high enough that
+// only exceptionally slow queries are explained. Disabled by default.
+func WithExplain(threshold time.Duration, explain ExplainFunc) SQLMiddlewareOption {
+	return func(o *sqlMiddlewareOptions) {
+		o.explainAfter = threshold
+		o.explain = explain
+	}
+}
+
+// logQueryPlan logs the plan of the given query, as returned by the explain function, on the span
+func (o sqlMiddlewareOptions) logQueryPlan(ctx context.Context, span opentracing.Span, query string, args ...interface{}) {
+	plan, err := o.explain(ctx, query, args...)
+	if err != nil {
+		span.LogKV("event", "query plan failed", "error", err.Error())
+		return
+	}
+	if len(plan) > maxExplainLength {
+		plan = plan[:maxExplainLength]
+	}
+	span.LogKV("event", "query plan", "db.plan", plan)
+}
+
+// WithSkippedQueries disables tracing of the given queries, such as "SELECT 1" liveness pings.
+// Each entry is matched against both the query name and the whitespace-trimmed query statement.
+// No span is created for skipped queries. Queries with empty statements are always skipped.
+func WithSkippedQueries(queries ...string) SQLMiddlewareOption {
+	return func(o *sqlMiddlewareOptions) {
+		o.skippedQueries = make(map[string]bool, len(queries))
+		for _, query := range queries {
+			o.skippedQueries[query] = true
+		}
+	}
+}
+
+// isSkipped returns true if no span should be created for the given query
+func (o sqlMiddlewareOptions) isSkipped(queryName, query string) bool {
+	statement := strings.TrimSpace(query)
+	if statement == "" {
+		return true
+	}
+	return (queryName != "" && o.skippedQueries[queryName]) || o.skippedQueries[statement]
+}
+
+// sqlCallerSkipPrefixes lists the function name prefixes of frames skipped when finding the
+// caller of a SQL query
+var sqlCallerSkipPrefixes = []string{
+	"runtime.",
+	"context.",
+	"database/sql.",
+	"github.com/gchaincl/sqlhooks",
+	"github.com/jmoiron/sqlx",
+	"github.com/spothero/tools/sql",
+	"github.com/spothero/tools/tracing.NewSQLMiddleware",
+	"github.com/spothero/tools/tracing.SQLMiddleware",
+	"github.com/spothero/tools/tracing.sqlCaller",
+}
+
+// sqlCaller returns the file:line of the first frame on the call stack outside of the SQL
+// libraries and middleware, or an empty string if no such frame could be found
+func sqlCaller() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	for {
+		frame, more := frames.Next()
+		skip := false
+		for _, prefix := range sqlCallerSkipPrefixes {
+			if strings.HasPrefix(frame.Function, prefix) {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// NewSQLMiddleware returns the tracing SQL middleware configured with the given options. See
+// SQLMiddleware for details on the behavior of the middleware.
+func NewSQLMiddleware(opts ...SQLMiddlewareOption) sql.MiddlewareStart {
+	options := sqlMiddlewareOptions{now: time.Now}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return func(ctx context.Context, queryName, query string, args ...interface{}) (context.Context, sql.MiddlewareEnd, error) {
+		if options.isSkipped(queryName, query) || !acquireSpan(ctx) {
+			return ctx, func(ctx context.Context, queryName, query string, queryErr error, args ...interface{}) (context.Context, error) {
+				return ctx, nil
+			}, nil
+		}
+		spanName := "db"
+		if queryName != "" {
+			spanName = fmt.Sprintf("%s_%s", spanName, queryName)
+		}
+		if options.tracer != nil {
+			ctx = ContextWithTracer(ctx, options.tracer)
+		}
+		var startOptions []opentracing.StartSpanOption
+		if options.version != "" {
+			startOptions = append(startOptions, opentracing.Tag{Key: "version", Value: options.version})
+		}
+		span, spanCtx := StartSpanFromContext(ctx, spanName, startOptions...)
+		span = span.
+			SetTag("component", "tracing").
+			SetTag("db.type", "sql").
+			SetTag("db.statement", query).
+			SetTag("db.statement.arguments", args).
+			SetTag("db.args_count", len(args))
+		if options.driver != "" {
+			span = span.SetTag("db.driver", options.driver)
+		}
+		if retries, ok := ctx.Value(sqlRetryCtxKey).(int); ok {
+			span = span.SetTag("db.retry_count", retries)
+		}
+		if options.tagCaller {
+			if caller := sqlCaller(); caller != "" {
+				span = span.SetTag("db.caller", caller)
+			}
+		}
+		if options.dbStats != nil {
+			stats := options.dbStats()
+			span = span.
+				SetTag("db.pool.in_use", stats.InUse).
+				SetTag("db.pool.wait_count", stats.WaitCount)
+		}
+		verbose := IsSpanVerbose(ctx)
+		if verbose {
+			span.LogKV("event", "query started", "db.statement.argument_count", len(args))
+		}
+		startTime := options.now()
+		mwEnd := func(ctx context.Context, queryName, query string, queryErr error, args ...interface{}) (context.Context, error) {
+			defer finishSpan(span, opentracing.FinishOptions{})
+			if options.explain != nil && queryErr == nil && options.now().Sub(startTime) > options.explainAfter {
+				options.logQueryPlan(ctx, span, query, args...)
+			}
+			if verbose {
+				if queryErr != nil {
+					span.LogKV("event", "query failed", "error", queryErr.Error())
+				} else {
+					span.LogKV("event", "query completed")
+				}
+			}
+			if queryErr != nil {
+				// Cancellation is typically caused by the client disconnecting rather than a
+				// database failure, so canceled queries are not marked as errored
+				if errorKind := ClassifyError(0, queryErr); errorKind != "" {
+					span = span.SetTag("db.canceled", true).SetTag("error.kind", errorKind)
+				} else {
+					span = span.SetTag("error", true)
+				}
+				if code := sqlErrorCode(queryErr); code != "" {
+					span = span.SetTag("db.error_code", code)
+				}
+			}
+			return ctx, nil
+		}
+		return EmbedCorrelationID(spanCtx), mwEnd, nil
+	}
+}
+
+// sqlStateError is implemented by driver errors carrying a SQLSTATE code, such as the errors of
+// pgx and lib/pq
+type sqlStateError interface {
+	SQLState() string
+}
+
+// sqlErrorCode returns the structured error code of the given database error, or an empty string
+// if the error carries no code. The error chain is searched for errors implementing SQLState, and
+// otherwise for driver error structs with an exported Code string field, such as
+// pgconn.PgError, or an exported Number integer field, such as mysql.MySQLError. Reflection is
+// used so that no database drivers need to be imported.
+func sqlErrorCode(err error) string {
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		if code := stateErr.SQLState(); code != "" {
+			return code
+		}
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		value := reflect.ValueOf(err)
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Struct {
+			continue
+		}
+		if field, ok := value.Type().FieldByName("Code"); ok && field.PkgPath == "" && field.Type.Kind() == reflect.String {
+			if code := value.FieldByIndex(field.Index).String(); code != "" {
+				return code
+			}
+		}
+		if field, ok := value.Type().FieldByName("Number"); ok && field.PkgPath == "" {
+			number := value.FieldByIndex(field.Index)
+			switch number.Kind() {
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				if number.Uint() != 0 {
+					return strconv.FormatUint(number.Uint(), 10)
+				}
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				if number.Int() != 0 {
+					return strconv.FormatInt(number.Int(), 10)
+				}
+			}
+		}
+	}
+	return ""
+}
+
 // SQLMiddleware traces requests made against SQL databases.
 //
 // Span names always start with "db". If a queryName is provided (highly recommended), the span
-// name will include the queryname in the format "db_<queryName>"
+// name will include the queryname in the format "db_<queryName>". Queries with empty statements
+// are not traced. Queries beyond the span limit of the context, see WithSpanLimit, are not traced.
 //
 // The following tags are placed on all SQL traces:
 // * component - Always set to "tracing"
 // * db.type - Always set to "sql"
 // * db.statement - Always set to the query statement
+// * db.args_count - Always set to the number of query arguments
+// * db.retry_count - Set only if a retry count was set on the context with WithSQLRetryCount
 // * error - Set to true only if an error was encountered with the query
+// * db.canceled - Set to true instead of error if the query context was canceled or timed out
+// * error.kind - Set to canceled or timeout when the query was canceled, see ClassifyError
+// * db.error_code - Set to the driver error code of failed queries, such as a Postgres SQLSTATE
+//
+// If spans are verbose on the query context, see WithSpanVerbose, the start and outcome of the
+// query are also logged on the span.
 func SQLMiddleware(ctx context.Context, queryName, query string, args ...interface{}) (context.Context, sql.MiddlewareEnd, error) {
-	spanName := "db"
-	if queryName != "" {
-		spanName = fmt.Sprintf("%s_%s", spanName, queryName)
-	}
-	span, spanCtx := opentracing.StartSpanFromContext(ctx, spanName)
+	return NewSQLMiddleware()(ctx, queryName, query, args...)
+}
+
+// StartTx starts a span for a SQL transaction. SQL middleware spans started with the returned
+// context become children of the transaction span. The returned function must be called with the
+// outcome of the transaction to finish the span. If the error is nil, the span is tagged with
+// db.transaction set to "commit", otherwise db.transaction is set to "rollback" and the span is
+// tagged as errored.
+func StartTx(ctx context.Context) (context.Context, func(err error)) {
+	span, spanCtx := StartSpanFromContext(ctx, "db_transaction")
 	span = span.
 		SetTag("component", "tracing").
-		SetTag("db.type", "sql").
-		SetTag("db.statement", query).
-		SetTag("db.statement.arguments", args)
-	mwEnd := func(ctx context.Context, queryName, query string, queryErr error, args ...interface{}) (context.Context, error) {
+		SetTag("db.type", "sql")
+	return EmbedCorrelationID(spanCtx), func(err error) {
 		defer span.Finish()
-		if queryErr != nil {
-			span = span.SetTag("error", true)
+		if err != nil {
+			span.SetTag("db.transaction", "rollback").SetTag("error", true)
+			return
 		}
-		return ctx, nil
+		span.SetTag("db.transaction", "commit")
 	}
-	return EmbedCorrelationID(spanCtx), mwEnd, nil
 }
@@ -15,18 +15,32 @@
 package tracing
 
 import (
+	"bufio"
 	"context"
+	"database/sql"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/spothero/tools/http/mock"
 	"github.com/spothero/tools/http/writer"
+	"github.com/spothero/tools/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	jaeger "github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestSetSpanTags(t *testing.T) {
@@ -39,7 +53,7 @@ func TestSetSpanTags(t *testing.T) {
 	mockReq.Header.Set("Content-Length", "1")
 
 	// There's not much we can test here since we can't access the underlying tags
-	span = setSpanTags(mockReq, span)
+	span = setSpanTags(mockReq, span, mockReq.URL.String(), "/path")
 	assert.NotNil(t, span)
 }
 
@@ -279,3 +293,1543 @@ func TestSQLMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPServerMiddlewareSlowSpanThreshold(t *testing.T) {
+	tests := []struct {
+		name          string
+		threshold     time.Duration
+		handlerTime   time.Duration
+		expectWarning bool
+	}{
+		{
+			"slow span logging is disabled by default",
+			0,
+			time.Minute,
+			false,
+		},
+		{
+			"requests faster than the threshold are not logged",
+			time.Second,
+			500 * time.Millisecond,
+			false,
+		},
+		{
+			"requests slower than the threshold are logged",
+			time.Second,
+			2 * time.Second,
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+			opentracing.SetGlobalTracer(tracer)
+
+			core, recordedLogs := observer.New(zapcore.WarnLevel)
+			loggerMiddleware := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(w, r.WithContext(log.NewContext(r.Context(), zap.New(core))))
+				})
+			}
+
+			// The handler advances the controllable clock to simulate a slow request
+			currentTime := time.Unix(0, 0)
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				currentTime = currentTime.Add(test.handlerTime)
+				w.WriteHeader(http.StatusTeapot)
+			})
+			mw := NewHTTPServerMiddleware(
+				WithSlowSpanThreshold(test.threshold),
+				func(o *middlewareOptions) { o.now = func() time.Time { return currentTime } },
+			)
+
+			recorder := httptest.NewRecorder()
+			handler := writer.StatusRecorderMiddleware(loggerMiddleware(mw(testHandler)))
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/path", nil))
+
+			slowLogs := recordedLogs.FilterMessage("slow http request").All()
+			if test.expectWarning {
+				require.Len(t, slowLogs, 1)
+				fields := slowLogs[0].ContextMap()
+				assert.Equal(t, int64(http.StatusTeapot), fields["http.status_code"])
+				assert.Equal(t, test.handlerTime, fields["http.duration"])
+				assert.Contains(t, fields, "http.path")
+			} else {
+				assert.Len(t, slowLogs, 0)
+			}
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareMethodOperationName(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              []MiddlewareOption
+		expectedOperation string
+	}{
+		{
+			"operation names default to the route path template",
+			nil,
+			"/orders",
+		},
+		{
+			"operation names are prefixed with the method when enabled",
+			[]MiddlewareOption{WithMethodOperationName(true)},
+			"POST /orders",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			router := mux.NewRouter()
+			router.Handle("/orders", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			router.Use(NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...))
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/orders", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedOperation, spans[0].OperationName)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareOperationSanitizer(t *testing.T) {
+	idPattern := regexp.MustCompile(`/[0-9]+`)
+	collapseIDs := func(operationName string) string {
+		return idPattern.ReplaceAllString(operationName, "/:id")
+	}
+	tests := []struct {
+		name              string
+		opts              []MiddlewareOption
+		expectedOperation string
+	}{
+		{"operation names are not sanitized by default", nil, "/orders/123/items/456"},
+		{"operation names are sanitized", []MiddlewareOption{WithOperationSanitizer(collapseIDs)}, "/orders/:id/items/:id"},
+		{
+			"operation names are sanitized after the method is applied",
+			[]MiddlewareOption{WithMethodOperationName(true), WithOperationSanitizer(strings.ToLower)},
+			"post /orders/123/items/456",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			// Routers which resolve raw paths produce high-cardinality operation names
+			resolver := writer.RouteResolverFunc(func(r *http.Request) string { return r.URL.Path })
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			handler := writer.RouteResolverMiddleware(resolver)(mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/orders/123/items/456", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedOperation, spans[0].OperationName)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareRouteTag(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware func(tracer opentracing.Tracer) mux.MiddlewareFunc
+	}{
+		{
+			"HTTPServerMiddleware tags the route",
+			func(tracer opentracing.Tracer) mux.MiddlewareFunc {
+				opentracing.SetGlobalTracer(tracer)
+				return HTTPServerMiddleware
+			},
+		},
+		{
+			"NewHTTPServerMiddleware tags the route",
+			func(tracer opentracing.Tracer) mux.MiddlewareFunc {
+				return NewHTTPServerMiddleware(WithTracer(tracer), WithMethodOperationName(true))
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+			tracer := mocktracer.New()
+			router := mux.NewRouter()
+			router.Handle("/orders/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			router.Use(test.middleware(tracer))
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders/123", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, "/orders/{id}", spans[0].Tag("http.route"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []MiddlewareOption
+		key         string
+		expectedTag interface{}
+	}{
+		{"idempotency keys are not tagged by default", nil, "retry-me", nil},
+		{"requests without the header are not tagged", []MiddlewareOption{WithIdempotencyKey("Idempotency-Key")}, "", nil},
+		{
+			"a hash of the idempotency key is tagged",
+			[]MiddlewareOption{WithIdempotencyKey("Idempotency-Key")},
+			"retry-me",
+			"29a93ef45314fcad56e8532df3a5bfb240a756be66ed32963a703111f5708656",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			req := httptest.NewRequest("POST", "/path", nil)
+			if test.key != "" {
+				req.Header.Set("Idempotency-Key", test.key)
+			}
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedTag, spans[0].Tag("http.idempotency_key"))
+			for _, value := range spans[0].Tags() {
+				assert.NotEqual(t, "retry-me", value)
+			}
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareLogsOnlyFallback(t *testing.T) {
+	tests := []struct {
+		name                string
+		enabled             bool
+		expectCorrelationID bool
+	}{
+		{"requests are correlated without spans when tracing is disabled", false, true},
+		{"requests are traced when tracing is enabled", true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, err := Config{Enabled: test.enabled, ServiceName: "test", DisableGlobalTracer: true}.NewTracer()
+			require.NoError(t, err)
+			defer tracer.Closer.Close()
+			core, recordedLogs := observer.New(zapcore.InfoLevel)
+			loggerMiddleware := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(w, r.WithContext(log.NewContext(r.Context(), zap.New(core))))
+				})
+			}
+			var correlationID string
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				correlationID = GetCorrelationID(r.Context())
+				log.Get(r.Context()).Info("handled")
+			})
+			mw := NewHTTPServerMiddleware(WithTracer(tracer.Tracer), WithLogsOnlyFallback("X-Correlation-Id"))
+			recorder := httptest.NewRecorder()
+			loggerMiddleware(mw(testHandler)).ServeHTTP(recorder, httptest.NewRequest("GET", "/path", nil))
+
+			if !test.expectCorrelationID {
+				assert.Empty(t, recorder.Header().Get("X-Correlation-Id"))
+				return
+			}
+			assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", correlationID)
+			assert.Equal(t, correlationID, recorder.Header().Get("X-Correlation-Id"))
+			logs := recordedLogs.All()
+			require.Len(t, logs, 1)
+			assert.Equal(t, correlationID, logs[0].ContextMap()["correlation_id"])
+		})
+	}
+}
+
+// hijackableRecorder is a ResponseRecorder which supports hijacking the connection
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+// Hijack implements http.Hijacker
+func (hr *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hr.hijacked = true
+	server, _ := net.Pipe()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func TestHTTPServerMiddlewareUpgrade(t *testing.T) {
+	tests := []struct {
+		name            string
+		headers         map[string]string
+		expectedUpgrade interface{}
+	}{
+		{"normal requests are not tagged", nil, nil},
+		{"upgrade headers without a connection upgrade are not tagged", map[string]string{"Upgrade": "websocket"}, nil},
+		{"websocket upgrades are tagged", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "WebSocket"}, "websocket"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hijacker, ok := w.(http.Hijacker)
+				require.True(t, ok)
+				conn, _, err := hijacker.Hijack()
+				require.NoError(t, err)
+				conn.Close()
+			})
+			req := httptest.NewRequest("GET", "/socket", nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			recorder := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+			NewHTTPServerMiddleware(WithTracer(tracer))(testHandler).ServeHTTP(recorder, req)
+			assert.True(t, recorder.hijacked)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedUpgrade, spans[0].Tag("http.upgrade"))
+			upgradeLogged := false
+			for _, record := range spans[0].Logs() {
+				for _, field := range record.Fields {
+					if field.Key == "event" && field.ValueString == "connection upgraded" {
+						upgradeLogged = true
+					}
+				}
+			}
+			assert.Equal(t, test.expectedUpgrade != nil, upgradeLogged)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareHostTag(t *testing.T) {
+	tracer := mocktracer.New()
+	req := httptest.NewRequest("GET", "/path", nil)
+	req.Host = "vanity.example.com"
+	NewHTTPServerMiddleware(WithTracer(tracer))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "vanity.example.com", spans[0].Tag("http.host"))
+}
+
+func TestHTTPServerMiddlewareQueueTime(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name         string
+		opts         []MiddlewareOption
+		requestStart string
+		expectedTime interface{}
+	}{
+		{"queue time is not tagged by default", nil, "t=1599999999.750", nil},
+		{"fractional seconds are supported", []MiddlewareOption{WithQueueTime("X-Request-Start")}, "t=1599999999.750", int64(250)},
+		{"milliseconds are supported", []MiddlewareOption{WithQueueTime("X-Request-Start")}, "1599999999900", int64(100)},
+		{"microseconds are supported", []MiddlewareOption{WithQueueTime("X-Request-Start")}, "t=1599999999990000", int64(10)},
+		{"seconds are supported", []MiddlewareOption{WithQueueTime("X-Request-Start")}, "1599999998", int64(2000)},
+		{"missing timestamps are ignored", []MiddlewareOption{WithQueueTime("X-Request-Start")}, "", nil},
+		{"malformed timestamps are ignored", []MiddlewareOption{WithQueueTime("X-Request-Start")}, "t=yesterday", nil},
+		{"future timestamps are ignored", []MiddlewareOption{WithQueueTime("X-Request-Start")}, "1600000001000", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			req := httptest.NewRequest("GET", "/path", nil)
+			if test.requestStart != "" {
+				req.Header.Set("X-Request-Start", test.requestStart)
+			}
+			opts := append(test.opts, WithTracer(tracer), WithClock(func() time.Time { return now }))
+			NewHTTPServerMiddleware(opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+				ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedTime, spans[0].Tag("http.queue_time_ms"))
+		})
+	}
+}
+
+func TestSQLMiddlewareDBStats(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	stubStats := func() sql.DBStats {
+		return sql.DBStats{InUse: 3, WaitCount: 7}
+	}
+	ctx, mwEnd, err := NewSQLMiddleware(WithDBStats(stubStats))(context.Background(), "getAllTests", "SELECT * FROM tests")
+	require.NoError(t, err)
+	_, err = mwEnd(ctx, "getAllTests", "SELECT * FROM tests", nil)
+	require.NoError(t, err)
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, 3, spans[0].Tag("db.pool.in_use"))
+	assert.Equal(t, int64(7), spans[0].Tag("db.pool.wait_count"))
+}
+
+func TestHTTPServerMiddlewareRequestBodySize(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []MiddlewareOption
+		expectedSize interface{}
+	}{
+		{
+			"request body size is not tagged by default",
+			nil,
+			nil,
+		},
+		{
+			"request body size of chunked requests is tagged when enabled",
+			[]MiddlewareOption{WithRequestBodySize(true)},
+			int64(11),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.Equal(t, "hello world", string(body))
+			})
+			// Wrapping the body in a MultiReader hides its length, so the request is chunked
+			req := httptest.NewRequest("POST", "/path", io.MultiReader(strings.NewReader("hello world")))
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+			NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedSize, spans[0].Tag("http.request_size"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareBodyOrderingCheck(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []MiddlewareOption
+		preConsume    bool
+		expectWarning bool
+	}{
+		{"pre-consumed bodies are not checked by default", []MiddlewareOption{WithRequestBodySize(true)}, true, false},
+		{"unconsumed bodies are not warned about", []MiddlewareOption{WithRequestBodySize(true), WithBodyOrderingCheck(true)}, false, false},
+		{"pre-consumed bodies are warned about", []MiddlewareOption{WithRequestBodySize(true), WithBodyOrderingCheck(true)}, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, recordedLogs := observer.New(zapcore.WarnLevel)
+			// Simulates a misordered middleware which reads the body before tracing wraps it
+			outerMiddleware := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if test.preConsume {
+						_, err := ioutil.ReadAll(r.Body)
+						require.NoError(t, err)
+					}
+					next.ServeHTTP(w, r.WithContext(log.NewContext(r.Context(), zap.New(core))))
+				})
+			}
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+			})
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(mocktracer.New()))...)
+			req := httptest.NewRequest("POST", "/path", strings.NewReader("hello world"))
+			outerMiddleware(mw(testHandler)).ServeHTTP(httptest.NewRecorder(), req)
+
+			warnings := recordedLogs.FilterMessage("http request body was consumed before the tracing middleware").All()
+			if test.expectWarning {
+				require.Len(t, warnings, 1)
+				assert.Equal(t, int64(11), warnings[0].ContextMap()["http.content_length"])
+				assert.Equal(t, int64(0), warnings[0].ContextMap()["http.request_size"])
+			} else {
+				assert.Len(t, warnings, 0)
+			}
+		})
+	}
+}
+
+func TestStartTx(t *testing.T) {
+	tests := []struct {
+		name            string
+		txErr           error
+		expectedOutcome string
+	}{
+		{"successful transactions are tagged as committed", nil, "commit"},
+		{"failed transactions are tagged as rolled back", fmt.Errorf("tx error"), "rollback"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			txCtx, finishTx := StartTx(context.Background())
+			for _, queryName := range []string{"first", "second"} {
+				ctx, mwEnd, err := SQLMiddleware(txCtx, queryName, "SELECT 1")
+				require.NoError(t, err)
+				_, err = mwEnd(ctx, queryName, "SELECT 1", nil)
+				require.NoError(t, err)
+			}
+			finishTx(test.txErr)
+
+			spans := make(map[string]*mocktracer.MockSpan)
+			for _, span := range tracer.FinishedSpans() {
+				spans[span.OperationName] = span
+			}
+			require.Len(t, spans, 3)
+			txSpan := spans["db_transaction"]
+			txSpanID := txSpan.Context().(mocktracer.MockSpanContext).SpanID
+			assert.Equal(t, txSpanID, spans["db_first"].ParentID)
+			assert.Equal(t, txSpanID, spans["db_second"].ParentID)
+			assert.Equal(t, test.expectedOutcome, txSpan.Tag("db.transaction"))
+			assert.Equal(t, test.txErr != nil, txSpan.Tag("error") != nil)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareSpanIDLogging(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         []MiddlewareOption
+		expectSpanID bool
+	}{
+		{"span ids are not logged by default", nil, false},
+		{"span ids are logged when enabled", []MiddlewareOption{WithSpanIDLogging(true)}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+			core, recordedLogs := observer.New(zapcore.InfoLevel)
+
+			var spanID string
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+				require.True(t, ok)
+				spanID = spanCtx.SpanID().String()
+				log.Get(r.Context()).Info("handled")
+			})
+			req := httptest.NewRequest("GET", "/path", nil)
+			req = req.WithContext(log.NewContext(req.Context(), zap.New(core)))
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+			logs := recordedLogs.All()
+			require.Len(t, logs, 1)
+			fields := logs[0].ContextMap()
+			assert.Contains(t, fields, "correlation_id")
+			if test.expectSpanID {
+				assert.Equal(t, spanID, fields["span_id"])
+			} else {
+				assert.NotContains(t, fields, "span_id")
+			}
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareCacheHeader(t *testing.T) {
+	tests := []struct {
+		name          string
+		cacheStatus   string
+		expectedCache interface{}
+	}{
+		{"cache hits are tagged", "HIT", "HIT"},
+		{"cache misses are tagged", "MISS", "MISS"},
+		{"responses without the cache header are not tagged", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.cacheStatus != "" {
+					w.Header().Set("X-Cache", test.cacheStatus)
+				}
+			})
+			mw := NewHTTPServerMiddleware(WithTracer(tracer), WithCacheHeader("X-Cache"))
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedCache, spans[0].Tag("http.cache"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareUnwrittenResponse(t *testing.T) {
+	tracer := mocktracer.New()
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mw := NewHTTPServerMiddleware(WithTracer(tracer))
+	// Recorders constructed without a status code must not produce a 0 status code tag
+	recorderMiddleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&writer.StatusRecorder{ResponseWriter: w}, r)
+		})
+	}
+	recorderMiddleware(mw(testHandler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "200", spans[0].Tag("http.status_code"))
+	assert.Nil(t, spans[0].Tag("error"))
+}
+
+func TestHTTPServerMiddlewareConditionalRequest(t *testing.T) {
+	tests := []struct {
+		name              string
+		header            string
+		value             string
+		statusCode        int
+		expectConditional interface{}
+	}{
+		{"etag validated requests answered with 304 are tagged", "If-None-Match", `"abc"`, http.StatusNotModified, true},
+		{"date validated requests answered with 304 are tagged", "If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT", http.StatusNotModified, true},
+		{"conditional requests answered in full are not tagged", "If-None-Match", `"abc"`, http.StatusOK, nil},
+		{"unconditional requests are not tagged", "", "", http.StatusNotModified, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statusCode)
+			})
+			req := httptest.NewRequest("GET", "/path", nil)
+			if test.header != "" {
+				req.Header.Set(test.header, test.value)
+			}
+			mw := NewHTTPServerMiddleware(WithTracer(tracer))
+			writer.StatusRecorderMiddleware(mw(testHandler)).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectConditional, spans[0].Tag("http.conditional"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareCacheTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []MiddlewareOption
+		ttl         time.Duration
+		expectedTTL interface{}
+	}{
+		{"cache ttls are tagged in seconds", []MiddlewareOption{WithCacheTTL(true)}, 5 * time.Minute, int64(300)},
+		{"uncacheable responses are tagged", []MiddlewareOption{WithCacheTTL(true)}, 0, int64(0)},
+		{"unset cache ttls are not tagged", []MiddlewareOption{WithCacheTTL(true)}, -1, nil},
+		{"cache ttls are not tagged by default", nil, 5 * time.Minute, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.ttl >= 0 {
+					SetCacheTTL(r.Context(), test.ttl)
+				}
+			})
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedTTL, spans[0].Tag("http.cache_ttl_seconds"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareErrorOnClientError(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []MiddlewareOption
+		statusCode  int
+		expectError bool
+	}{
+		{"404s are not errors by default", nil, http.StatusNotFound, false},
+		{"500s are errors by default", nil, http.StatusInternalServerError, true},
+		{"404s are errors when enabled", []MiddlewareOption{WithErrorOnClientError(true)}, http.StatusNotFound, true},
+		{"200s are not errors when enabled", []MiddlewareOption{WithErrorOnClientError(true)}, http.StatusOK, false},
+		{"excluded 501s are not errors", []MiddlewareOption{WithNonErrorStatuses(http.StatusNotImplemented)}, http.StatusNotImplemented, false},
+		{"other 500s are errors when 501s are excluded", []MiddlewareOption{WithNonErrorStatuses(http.StatusNotImplemented)}, http.StatusBadGateway, true},
+		{"excluded 404s are not errors when enabled", []MiddlewareOption{WithErrorOnClientError(true), WithNonErrorStatuses(http.StatusNotFound)}, http.StatusNotFound, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statusCode)
+			})
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			writer.StatusRecorderMiddleware(mw(testHandler)).ServeHTTP(
+				httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectError, spans[0].Tag("error") == true)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareJaegerDebugHeader(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), reporter)
+	defer closer.Close()
+	core, recordedLogs := observer.New(zapcore.InfoLevel)
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+		require.True(t, ok)
+		assert.True(t, spanCtx.IsSampled())
+		assert.True(t, spanCtx.IsDebug())
+		log.Get(r.Context()).Info("handled")
+	})
+	req := httptest.NewRequest("GET", "/path", nil)
+	req.Header.Set(jaeger.JaegerDebugHeader, "support-ticket-123")
+	req = req.WithContext(log.NewContext(req.Context(), zap.New(core)))
+	NewHTTPServerMiddleware(WithTracer(tracer))(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	// The span is reported even though the tracer samples nothing
+	assert.Equal(t, 1, reporter.SpansSubmitted())
+	logs := recordedLogs.All()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "support-ticket-123", logs[0].ContextMap()["jaeger_debug_id"])
+}
+
+func TestSQLMiddlewareCallerTag(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	for _, tagCaller := range []bool{false, true} {
+		ctx, mwEnd, err := NewSQLMiddleware(WithCallerTag(tagCaller))(context.Background(), "query", "SELECT 1")
+		require.NoError(t, err)
+		_, err = mwEnd(ctx, "query", "SELECT 1", nil)
+		require.NoError(t, err)
+	}
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 2)
+	assert.Nil(t, spans[0].Tag("db.caller"))
+	caller, ok := spans[1].Tag("db.caller").(string)
+	require.True(t, ok)
+	assert.Regexp(t, `middleware_test\.go:\d+$`, caller)
+}
+
+func TestHTTPServerMiddlewareTraceCondition(t *testing.T) {
+	tests := []struct {
+		name          string
+		flagEnabled   bool
+		expectedSpans int
+	}{
+		{"requests are traced when the flag is enabled", true, 1},
+		{"requests are not traced when the flag is disabled", false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			handlerCalled := false
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			})
+			flag := func(context.Context) bool { return test.flagEnabled }
+			middleware := NewHTTPServerMiddleware(WithTracer(tracer), WithTraceCondition(flag))
+			middleware(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			assert.True(t, handlerCalled)
+			assert.Len(t, tracer.FinishedSpans(), test.expectedSpans)
+		})
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	t.Run("nil base client uses the default transport", func(t *testing.T) {
+		client := NewHTTPClient(nil)
+		rt, ok := client.Transport.(RoundTripper)
+		require.True(t, ok)
+		assert.Equal(t, http.DefaultTransport, rt.RoundTripper)
+	})
+
+	t.Run("base client settings are preserved", func(t *testing.T) {
+		base := &http.Client{Timeout: 5 * time.Second}
+		client := NewHTTPClient(base)
+		assert.Equal(t, 5*time.Second, client.Timeout)
+		assert.Nil(t, base.Transport)
+	})
+
+	t.Run("requests produce client spans", func(t *testing.T) {
+		tracer.Reset()
+		resp, err := NewHTTPClient(nil).Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		spans := tracer.FinishedSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, fmt.Sprintf("GET %s", server.URL), spans[0].OperationName)
+		assert.Equal(t, "200 OK", spans[0].Tag("http.status_code"))
+	})
+}
+
+func TestSQLMiddlewareRetryCount(t *testing.T) {
+	tests := []struct {
+		name            string
+		ctx             context.Context
+		expectedRetries interface{}
+	}{
+		{"queries without a retry count omit the tag", context.Background(), nil},
+		{"retried queries are tagged with the retry count", WithSQLRetryCount(context.Background(), 2), 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			ctx, mwEnd, err := SQLMiddleware(test.ctx, "updateTests", "UPDATE tests SET x = 1")
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, "updateTests", "UPDATE tests SET x = 1", nil)
+			require.NoError(t, err)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedRetries, spans[0].Tag("db.retry_count"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareSamplerTags(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        Config
+		expectedType  string
+		expectedParam float64
+	}{
+		{"unset sampler types are tagged as const", Config{SamplerParam: 1}, "const", 1},
+		{"configured samplers are tagged", Config{SamplerType: "probabilistic", SamplerParam: 0.25}, "probabilistic", 0.25},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			middleware := NewHTTPServerMiddleware(WithTracer(tracer), WithSamplerTags(test.config))
+			middleware(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedType, spans[0].Tag("sampler.type"))
+			assert.Equal(t, test.expectedParam, spans[0].Tag("sampler.param"))
+		})
+	}
+}
+
+func TestSQLMiddlewareSkippedQueries(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []SQLMiddlewareOption
+		queryName     string
+		query         string
+		expectedSpans int
+	}{
+		{"queries are traced by default", nil, "ping", "SELECT 1", 1},
+		{"empty statements are skipped by default", nil, "", " ", 0},
+		{"skipped statements are not traced", []SQLMiddlewareOption{WithSkippedQueries("SELECT 1")}, "", "  SELECT 1\n", 0},
+		{"skipped query names are not traced", []SQLMiddlewareOption{WithSkippedQueries("ping")}, "ping", "SELECT 1", 0},
+		{"other queries are still traced", []SQLMiddlewareOption{WithSkippedQueries("SELECT 1")}, "", "SELECT * FROM tests", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			ctx, mwEnd, err := NewSQLMiddleware(test.opts...)(context.Background(), test.queryName, test.query)
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, test.queryName, test.query, nil)
+			require.NoError(t, err)
+			assert.Len(t, tracer.FinishedSpans(), test.expectedSpans)
+		})
+	}
+}
+
+func TestSQLMiddlewareSpanVerbose(t *testing.T) {
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		queryErr     error
+		expectedLogs []string
+	}{
+		{"spans are not logged by default", context.Background(), nil, nil},
+		{"verbose spans log successful queries", WithSpanVerbose(context.Background()), nil, []string{"query started", "query completed"}},
+		{"verbose spans log failed queries", WithSpanVerbose(context.Background()), fmt.Errorf("query error"), []string{"query started", "query failed"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			ctx, mwEnd, err := SQLMiddleware(test.ctx, "getAllTests", "SELECT * FROM tests")
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, "getAllTests", "SELECT * FROM tests", test.queryErr)
+			require.NoError(t, err)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			var events []string
+			for _, record := range spans[0].Logs() {
+				for _, field := range record.Fields {
+					if field.Key == "event" {
+						events = append(events, field.ValueString)
+					}
+				}
+			}
+			assert.Equal(t, test.expectedLogs, events)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareRedactedQueryParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []MiddlewareOption
+		target      string
+		expectedURL string
+	}{
+		{
+			"query params are not redacted by default",
+			nil,
+			"/search?token=secret&q=cars",
+			"/search?token=secret&q=cars",
+		},
+		{
+			"configured query params are redacted and others are kept",
+			[]MiddlewareOption{WithRedactedQueryParams("token", "api_key")},
+			"/search?token=secret&q=cars&api_key=abc123&page=2",
+			"/search?token=REDACTED&q=cars&api_key=REDACTED&page=2",
+		},
+		{
+			"urls without query params are unchanged",
+			[]MiddlewareOption{WithRedactedQueryParams("token")},
+			"/search",
+			"/search",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Jaeger spans keep every value of a tag, so the unredacted URL must never be tagged
+			reporter := jaeger.NewInMemoryReporter()
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), reporter)
+			defer closer.Close()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler).ServeHTTP(
+				httptest.NewRecorder(), httptest.NewRequest("GET", test.target, nil))
+
+			spans := reporter.GetSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, []string{test.expectedURL}, jaegerTagValues(spans[0], "http.url"))
+		})
+	}
+}
+
+// jaegerTagValues returns every string value tagged on the jaeger span under the given key
+func jaegerTagValues(span opentracing.Span, key string) []string {
+	var values []string
+	for _, tag := range jaeger.BuildJaegerThrift(span.(*jaeger.Span)).Tags {
+		if tag.Key == key {
+			values = append(values, tag.GetVStr())
+		}
+	}
+	return values
+}
+
+func TestHTTPServerMiddlewareTagNormalization(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []MiddlewareOption
+		target      string
+		expectedURL string
+	}{
+		{
+			"urls are not normalized by default",
+			nil,
+			"/users/123",
+			"/users/123",
+		},
+		{
+			"numeric path segments are normalized",
+			[]MiddlewareOption{WithTagNormalization()},
+			"/users/123/orders/456?page=2",
+			"/users/:id/orders/:id?page=2",
+		},
+		{
+			"uuid path segments are normalized",
+			[]MiddlewareOption{WithTagNormalization()},
+			"/users/0b6b8a36-2f3c-4a8e-9a4f-5e1d2c3b4a59",
+			"/users/:uuid",
+		},
+		{
+			"custom patterns are applied",
+			[]MiddlewareOption{WithTagNormalization(PathSegmentPattern{Pattern: regexp.MustCompile(`^[A-Z]{3}$`), Placeholder: ":code"})},
+			"/airports/ORD/123",
+			"/airports/:code/123",
+		},
+		{
+			"normalized urls are also redacted",
+			[]MiddlewareOption{WithTagNormalization(), WithRedactedQueryParams("token")},
+			"/users/123?token=secret",
+			"/users/:id?token=REDACTED",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Jaeger spans keep every value of a tag, so only the normalized values may be tagged
+			reporter := jaeger.NewInMemoryReporter()
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), reporter)
+			defer closer.Close()
+			// Routers which resolve raw paths produce high-cardinality path templates
+			resolver := writer.RouteResolverFunc(func(r *http.Request) string { return r.URL.Path })
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			writer.RouteResolverMiddleware(resolver)(mw(testHandler)).ServeHTTP(
+				httptest.NewRecorder(), httptest.NewRequest("GET", test.target, nil))
+
+			spans := reporter.GetSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, []string{test.expectedURL}, jaegerTagValues(spans[0], "http.url"))
+			assert.Equal(t, []string{strings.SplitN(test.expectedURL, "?", 2)[0]}, jaegerTagValues(spans[0], "http.path"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareRequestIDBaggage(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              []MiddlewareOption
+		requestID         string
+		expectedRequestID string
+	}{
+		{"request ids are not propagated by default", nil, "abc-123", ""},
+		{"request ids are set as baggage and tagged", []MiddlewareOption{WithRequestIDBaggage("X-Request-Id")}, "abc-123", "abc-123"},
+		{"requests without a request id are unaffected", []MiddlewareOption{WithRequestIDBaggage("X-Request-Id")}, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			var baggage string
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				baggage = opentracing.SpanFromContext(r.Context()).BaggageItem("request_id")
+			})
+			req := httptest.NewRequest("GET", "/", nil)
+			if test.requestID != "" {
+				req.Header.Set("X-Request-Id", test.requestID)
+			}
+			NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedRequestID, baggage)
+			if test.expectedRequestID != "" {
+				assert.Equal(t, test.expectedRequestID, spans[0].Tag("request_id"))
+			} else {
+				assert.Nil(t, spans[0].Tag("request_id"))
+			}
+		})
+	}
+}
+
+func TestRoundTripTimeToFirstByte(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		httpTrace  bool
+		expectTTFB bool
+	}{
+		{"time to first byte is not tagged by default", false, false},
+		{"time to first byte is tagged with http tracing", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			client := &http.Client{Transport: RoundTripper{RoundTripper: http.DefaultTransport, HTTPTrace: test.httpTrace}}
+			resp, err := client.Get(server.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			if test.expectTTFB {
+				ttfb, ok := spans[0].Tag("http.ttfb_ms").(int64)
+				require.True(t, ok)
+				assert.GreaterOrEqual(t, ttfb, int64(50))
+			} else {
+				assert.Nil(t, spans[0].Tag("http.ttfb_ms"))
+			}
+		})
+	}
+}
+
+func TestRoundTripPeerIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		peerIP       bool
+		expectedPeer interface{}
+	}{
+		{"peer ips are not tagged by default", false, nil},
+		{"peer ips are tagged when enabled", true, "127.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			client := &http.Client{Transport: RoundTripper{RoundTripper: http.DefaultTransport, PeerIP: test.peerIP}}
+			resp, err := client.Get(server.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedPeer, spans[0].Tag("peer.ipv4"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareStartSpanOptions(t *testing.T) {
+	tracer := mocktracer.New()
+	startTime := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	middleware := NewHTTPServerMiddleware(
+		WithTracer(tracer),
+		WithStartSpanOptions(opentracing.StartTime(startTime), opentracing.Tag{Key: "replayed", Value: true}),
+	)
+	middleware(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, startTime, spans[0].StartTime)
+	assert.Equal(t, true, spans[0].Tag("replayed"))
+}
+
+func TestSQLMiddlewareDriverName(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []SQLMiddlewareOption
+		expectedDriver interface{}
+	}{
+		{"the driver is not tagged by default", nil, nil},
+		{"empty driver names are not tagged", []SQLMiddlewareOption{WithDriverName("")}, nil},
+		{"the driver name is tagged", []SQLMiddlewareOption{WithDriverName("pgx")}, "pgx"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			ctx, mwEnd, err := NewSQLMiddleware(test.opts...)(context.Background(), "getAllTests", "SELECT * FROM tests")
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, "getAllTests", "SELECT * FROM tests", nil)
+			require.NoError(t, err)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedDriver, spans[0].Tag("db.driver"))
+		})
+	}
+}
+
+func TestSQLMiddlewareArgsCount(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []interface{}
+		expectedCount int
+	}{
+		{"queries without arguments have a count of zero", nil, 0},
+		{"all query arguments are counted", []interface{}{1, "two", 3.0, nil}, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			query := "SELECT * FROM tests WHERE a = $1 AND b = $2 AND c = $3 AND d = $4"
+			ctx, mwEnd, err := SQLMiddleware(context.Background(), "getTests", query, test.args...)
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, "getTests", query, nil, test.args...)
+			require.NoError(t, err)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedCount, spans[0].Tag("db.args_count"))
+		})
+	}
+}
+
+func TestSQLMiddlewareExplain(t *testing.T) {
+	tests := []struct {
+		name          string
+		duration      time.Duration
+		queryErr      error
+		explainErr    error
+		expectedEvent string
+	}{
+		{"fast queries are not explained", 10 * time.Millisecond, nil, nil, ""},
+		{"failed queries are not explained", time.Second, fmt.Errorf("query error"), nil, ""},
+		{"slow queries are explained", time.Second, nil, nil, "query plan"},
+		{"explain failures are logged", time.Second, nil, fmt.Errorf("explain error"), "query plan failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			var explainedQuery string
+			var explainedArgs []interface{}
+			explain := func(ctx context.Context, query string, args ...interface{}) (string, error) {
+				explainedQuery = query
+				explainedArgs = args
+				return strings.Repeat("Seq Scan on tests ", 500), test.explainErr
+			}
+			currentTime := time.Unix(0, 0)
+			mw := NewSQLMiddleware(
+				WithExplain(100*time.Millisecond, explain),
+				func(o *sqlMiddlewareOptions) { o.now = func() time.Time { return currentTime } },
+			)
+			query := "SELECT * FROM tests WHERE id = $1"
+			ctx, mwEnd, err := mw(context.Background(), "getTest", query, 1)
+			require.NoError(t, err)
+			currentTime = currentTime.Add(test.duration)
+			_, err = mwEnd(ctx, "getTest", query, test.queryErr, 1)
+			require.NoError(t, err)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			if test.expectedEvent == "" {
+				assert.Empty(t, explainedQuery)
+				assert.Len(t, spans[0].Logs(), 0)
+				return
+			}
+			assert.Equal(t, query, explainedQuery)
+			assert.Equal(t, []interface{}{1}, explainedArgs)
+			require.Len(t, spans[0].Logs(), 1)
+			fields := spans[0].Logs()[0].Fields
+			assert.Equal(t, test.expectedEvent, fields[0].ValueString)
+			if test.explainErr == nil {
+				assert.Equal(t, "db.plan", fields[1].Key)
+				assert.Len(t, fields[1].ValueString, 4096)
+			}
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareMinimumSpanDuration(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []MiddlewareOption
+		delay         time.Duration
+		statusCode    int
+		expectedSpans int
+	}{
+		{"fast spans are kept by default", nil, 0, http.StatusOK, 1},
+		{"fast spans are dropped", []MiddlewareOption{WithMinimumSpanDuration(20 * time.Millisecond)}, 0, http.StatusOK, 0},
+		{"slow spans are kept", []MiddlewareOption{WithMinimumSpanDuration(20 * time.Millisecond)}, 30 * time.Millisecond, http.StatusOK, 1},
+		{"fast errored spans are kept", []MiddlewareOption{WithMinimumSpanDuration(20 * time.Millisecond)}, 0, http.StatusInternalServerError, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(test.delay)
+				w.WriteHeader(test.statusCode)
+			})
+			handler := writer.StatusRecorderMiddleware(NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			assert.Len(t, tracer.FinishedSpans(), test.expectedSpans)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareUserContextKey(t *testing.T) {
+	type userCtxKeyType int
+	const userCtxKey userCtxKeyType = iota
+	tests := []struct {
+		name         string
+		opts         []MiddlewareOption
+		expectedUser interface{}
+	}{
+		{"users are not tagged by default", nil, nil},
+		{"users are tagged from the configured context key", []MiddlewareOption{WithUserContextKey(userCtxKey)}, "12345"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest("GET", "/", nil)
+			req = req.WithContext(context.WithValue(req.Context(), userCtxKey, "12345"))
+			NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedUser, spans[0].Tag("user.id"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareExperimentContextKey(t *testing.T) {
+	type experimentCtxKeyType int
+	const experimentCtxKey experimentCtxKeyType = iota
+	tests := []struct {
+		name            string
+		opts            []MiddlewareOption
+		expectedVariant interface{}
+	}{
+		{"experiments are not tagged by default", nil, nil},
+		{"experiments are tagged from the configured context key", []MiddlewareOption{WithExperimentContextKey(experimentCtxKey)}, "treatment"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			req := httptest.NewRequest("GET", "/", nil)
+			req = req.WithContext(context.WithValue(req.Context(), experimentCtxKey, map[string]string{"checkout_flow": "treatment"}))
+			NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedVariant, spans[0].Tag("experiment.checkout_flow"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareResponseProgress(t *testing.T) {
+	tracer := mocktracer.New()
+	// Every write advances the controllable clock by one second
+	currentTime := time.Unix(0, 0)
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 4; i++ {
+			currentTime = currentTime.Add(time.Second)
+			_, _ = w.Write([]byte("chunk"))
+		}
+	})
+	mw := NewHTTPServerMiddleware(
+		WithTracer(tracer),
+		WithResponseProgress(2*time.Second),
+		func(o *middlewareOptions) { o.now = func() time.Time { return currentTime } },
+	)
+	writer.StatusRecorderMiddleware(mw(testHandler)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, int64(20), spans[0].Tag("http.response_size"))
+	var progress []interface{}
+	for _, record := range spans[0].Logs() {
+		for _, field := range record.Fields {
+			if field.Key == "http.response_size" {
+				progress = append(progress, field.ValueString)
+			}
+		}
+	}
+	// Progress is logged on every other write
+	assert.Equal(t, []interface{}{"10", "20"}, progress)
+}
+
+func TestHTTPServerMiddlewareErrorKind(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOutCtx, cancelTimeout := context.WithTimeout(context.Background(), 0)
+	defer cancelTimeout()
+	tests := []struct {
+		name         string
+		opts         []MiddlewareOption
+		ctx          context.Context
+		statusCode   int
+		expectedKind interface{}
+	}{
+		{"successful requests are not classified", nil, context.Background(), http.StatusOK, nil},
+		{"4XX responses are client errors", nil, context.Background(), http.StatusNotFound, "client"},
+		{"5XX responses are server errors", nil, context.Background(), http.StatusBadGateway, "server"},
+		{"exceeded deadlines are timeouts", nil, timedOutCtx, http.StatusOK, "timeout"},
+		{"canceled requests are canceled", nil, canceledCtx, http.StatusOK, "canceled"},
+		{
+			"the classifier can be overridden",
+			[]MiddlewareOption{WithErrorClassifier(func(statusCode int, ctxErr error) string {
+				if statusCode == http.StatusTooManyRequests {
+					return "throttled"
+				}
+				return ClassifyError(statusCode, ctxErr)
+			})},
+			context.Background(),
+			http.StatusTooManyRequests,
+			"throttled",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statusCode)
+			})
+			handler := writer.StatusRecorderMiddleware(NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(test.ctx))
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedKind, spans[0].Tag("error.kind"))
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareClock(t *testing.T) {
+	tracer := mocktracer.New()
+	currentTime := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentTime = currentTime.Add(1500 * time.Millisecond)
+	})
+	mw := NewHTTPServerMiddleware(WithTracer(tracer), WithClock(func() time.Time { return currentTime }))
+	mw(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC), spans[0].StartTime)
+	assert.Equal(t, 1500*time.Millisecond, spans[0].FinishTime.Sub(spans[0].StartTime))
+}
+
+func TestHTTPServerMiddlewareTenantHeader(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	core, recordedLogs := observer.New(zapcore.InfoLevel)
+
+	outbound := http.Header{}
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := opentracing.SpanFromContext(r.Context())
+		require.NoError(t, tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(outbound)))
+		log.Get(r.Context()).Info("handled")
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Tenant-Id", "acme")
+	req = req.WithContext(log.NewContext(req.Context(), zap.New(core)))
+	mw := NewHTTPServerMiddleware(WithTracer(tracer), WithTenantHeader("X-Tenant-Id"))
+	mw(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	// The tenant is propagated downstream as baggage
+	assert.Equal(t, "acme", outbound.Get("uberctx-tenant_id"))
+	// The tenant is added to the context logger
+	logs := recordedLogs.All()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "acme", logs[0].ContextMap()["tenant_id"])
+	// The tenant is tagged on the span
+	spans := reporter.GetSpans()
+	require.Len(t, spans, 1)
+	tags := make(map[string]string)
+	for _, tag := range jaeger.BuildJaegerThrift(spans[0].(*jaeger.Span)).Tags {
+		tags[tag.Key] = tag.GetVStr()
+	}
+	assert.Equal(t, "acme", tags["tenant.id"])
+}
+
+func TestSQLMiddlewareCanceled(t *testing.T) {
+	tests := []struct {
+		name             string
+		queryErr         error
+		expectedError    interface{}
+		expectedCanceled interface{}
+		expectedKind     interface{}
+	}{
+		{"successful queries are not tagged", nil, nil, nil, nil},
+		{"failed queries are marked as errored", fmt.Errorf("query error"), true, nil, nil},
+		{"canceled queries are tagged as canceled", fmt.Errorf("query: %w", context.Canceled), nil, true, "canceled"},
+		{"timed out queries are tagged as canceled", context.DeadlineExceeded, nil, true, "timeout"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			ctx, mwEnd, err := SQLMiddleware(context.Background(), "getAllTests", "SELECT * FROM tests")
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, "getAllTests", "SELECT * FROM tests", test.queryErr)
+			require.NoError(t, err)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedError, spans[0].Tag("error"))
+			assert.Equal(t, test.expectedCanceled, spans[0].Tag("db.canceled"))
+			assert.Equal(t, test.expectedKind, spans[0].Tag("error.kind"))
+		})
+	}
+}
+
+// stubPgError mimics pgconn.PgError, which carries a SQLSTATE code
+type stubPgError struct {
+	Code    string
+	Message string
+}
+
+func (e *stubPgError) Error() string    { return e.Message }
+func (e *stubPgError) SQLState() string { return e.Code }
+
+// stubPqError mimics lib/pq errors, whose code is an exported string field of a named type
+type stubPqError struct {
+	Code stubErrorCode
+}
+
+type stubErrorCode string
+
+func (e stubPqError) Error() string { return string(e.Code) }
+
+// stubMySQLError mimics mysql.MySQLError, which carries a numeric error code
+type stubMySQLError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *stubMySQLError) Error() string { return e.Message }
+
+func TestSQLMiddlewareErrorCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		queryErr     error
+		expectedCode interface{}
+	}{
+		{"successful queries are not tagged", nil, nil},
+		{"errors without codes are not tagged", fmt.Errorf("query error"), nil},
+		{"sqlstate errors are tagged", &stubPgError{Code: "23505", Message: "duplicate key"}, "23505"},
+		{"wrapped sqlstate errors are tagged", fmt.Errorf("insert: %w", &stubPgError{Code: "40001"}), "40001"},
+		{"errors with code fields are tagged", stubPqError{Code: "42P01"}, "42P01"},
+		{"errors with number fields are tagged", &stubMySQLError{Number: 1062, Message: "duplicate entry"}, "1062"},
+		{"errors with empty codes are not tagged", &stubMySQLError{Message: "unknown"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			ctx, mwEnd, err := SQLMiddleware(context.Background(), "getAllTests", "SELECT * FROM tests")
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, "getAllTests", "SELECT * FROM tests", test.queryErr)
+			require.NoError(t, err)
+
+			spans := tracer.FinishedSpans()
+			require.Len(t, spans, 1)
+			assert.Equal(t, test.expectedCode, spans[0].Tag("db.error_code"))
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opencensus
+
+import (
+	"context"
+	"sync"
+
+	"github.com/opentracing/opentracing-go"
+	"go.opencensus.io/trace"
+)
+
+// bridgedTrace tracks the OpenCensus spans of a single bridged trace
+type bridgedTrace struct {
+	// spans maps exported OpenCensus span IDs to their OpenTracing span contexts
+	spans map[trace.SpanID]opentracing.SpanContext
+	// pending holds spans which finished before their parent span was exported
+	pending map[trace.SpanID][]*trace.SpanData
+}
+
+// Bridge is an OpenCensus exporter which reports OpenCensus spans through an OpenTracing Tracer.
+// OpenCensus spans started under a context returned by NewContext are reported as children of
+// the OpenTracing span active on that context, preserving the nesting of the OpenCensus spans.
+//
+// The Bridge must be registered with OpenCensus through trace.RegisterExporter.
+type Bridge struct {
+	tracer opentracing.Tracer
+	traces map[trace.TraceID]*bridgedTrace
+	mutex  sync.Mutex
+}
+
+// NewBridge creates a new Bridge reporting OpenCensus spans through the given Tracer. If the
+// Tracer is nil, the OpenTracing global tracer is used.
+func NewBridge(tracer opentracing.Tracer) *Bridge {
+	if tracer == nil {
+		tracer = opentracing.GlobalTracer()
+	}
+	return &Bridge{tracer: tracer, traces: make(map[trace.TraceID]*bridgedTrace)}
+}
+
+// NewContext returns a context on which OpenCensus spans become children of the OpenTracing
+// span active on the given context. The returned function must be called once all OpenCensus
+// spans started under the context have ended in order to release the bridge bookkeeping. If no
+// OpenTracing span is active on the context, the context is returned unmodified.
+func (b *Bridge) NewContext(ctx context.Context) (context.Context, func()) {
+	parent := opentracing.SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, func() {}
+	}
+	// The bridge span is never ended, so it is never exported. It only serves as the OpenCensus
+	// parent for all spans started on the returned context.
+	ctx, bridgeSpan := trace.StartSpan(ctx, "opentracing", trace.WithSampler(trace.AlwaysSample()))
+	bridgeSpanCtx := bridgeSpan.SpanContext()
+	b.mutex.Lock()
+	b.traces[bridgeSpanCtx.TraceID] = &bridgedTrace{
+		spans:   map[trace.SpanID]opentracing.SpanContext{bridgeSpanCtx.SpanID: parent.Context()},
+		pending: make(map[trace.SpanID][]*trace.SpanData),
+	}
+	b.mutex.Unlock()
+	return ctx, func() {
+		b.mutex.Lock()
+		delete(b.traces, bridgeSpanCtx.TraceID)
+		b.mutex.Unlock()
+	}
+}
+
+// ExportSpan satisfies the OpenCensus Exporter interface. Spans which were not started under a
+// context returned by NewContext are ignored.
+func (b *Bridge) ExportSpan(sd *trace.SpanData) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	bridged, ok := b.traces[sd.TraceID]
+	if !ok {
+		return
+	}
+	parent, ok := bridged.spans[sd.ParentSpanID]
+	if !ok {
+		// Children typically end before their parents, so wait for the parent to be exported
+		bridged.pending[sd.ParentSpanID] = append(bridged.pending[sd.ParentSpanID], sd)
+		return
+	}
+	b.report(bridged, parent, sd)
+}
+
+// report reports the OpenCensus span as a child of the given parent, followed by any pending
+// children of the span
+func (b *Bridge) report(bridged *bridgedTrace, parent opentracing.SpanContext, sd *trace.SpanData) {
+	span := b.tracer.StartSpan(sd.Name, opentracing.ChildOf(parent), opentracing.StartTime(sd.StartTime))
+	for key, value := range sd.Attributes {
+		span = span.SetTag(key, value)
+	}
+	if sd.Code != trace.StatusCodeOK {
+		span = span.SetTag("error", true)
+	}
+	span.FinishWithOptions(opentracing.FinishOptions{FinishTime: sd.EndTime})
+	bridged.spans[sd.SpanID] = span.Context()
+
+	children := bridged.pending[sd.SpanID]
+	delete(bridged.pending, sd.SpanID)
+	for _, child := range children {
+		b.report(bridged, span.Context(), child)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opencensus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+)
+
+func TestBridge(t *testing.T) {
+	tracer := mocktracer.New()
+	bridge := NewBridge(tracer)
+	trace.RegisterExporter(bridge)
+	defer trace.UnregisterExporter(bridge)
+
+	parent, ctx := opentracing.StartSpanFromContextWithTracer(context.Background(), tracer, "parent")
+	ctx, release := bridge.NewContext(ctx)
+	defer release()
+
+	childCtx, child := trace.StartSpan(ctx, "child")
+	child.AddAttributes(trace.StringAttribute("key", "value"))
+	_, grandchild := trace.StartSpan(childCtx, "grandchild")
+	grandchild.SetStatus(trace.Status{Code: trace.StatusCodeInternal})
+	grandchild.End()
+	child.End()
+	parent.Finish()
+
+	spans := make(map[string]*mocktracer.MockSpan)
+	for _, span := range tracer.FinishedSpans() {
+		spans[span.OperationName] = span
+	}
+	require.Len(t, spans, 3)
+	parentSpanCtx := spans["parent"].Context().(mocktracer.MockSpanContext)
+	childSpanCtx := spans["child"].Context().(mocktracer.MockSpanContext)
+	assert.Equal(t, parentSpanCtx.SpanID, spans["child"].ParentID)
+	assert.Equal(t, parentSpanCtx.TraceID, childSpanCtx.TraceID)
+	assert.Equal(t, "value", spans["child"].Tag("key"))
+	assert.Equal(t, childSpanCtx.SpanID, spans["grandchild"].ParentID)
+	assert.Equal(t, true, spans["grandchild"].Tag("error"))
+}
+
+func TestBridgeWithoutSpan(t *testing.T) {
+	bridge := NewBridge(mocktracer.New())
+	ctx := context.Background()
+	bridgeCtx, release := bridge.NewContext(ctx)
+	defer release()
+	assert.Equal(t, ctx, bridgeCtx)
+}
@@ -0,0 +1,305 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uber/jaeger-client-go"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+// Span exporters
+const (
+	ExporterJaeger = "jaeger" // Report spans to jaeger agents over UDP
+	ExporterOTLP   = "otlp"   // Export spans to an OpenTelemetry collector using OTLP/HTTP
+)
+
+// OTLPProtocolHTTP is the OTLP/HTTP transport protocol, the only OTLP protocol supported by the
+// OTLP exporter
+const OTLPProtocolHTTP = "http"
+
+// otlpBatchSize is the number of spans buffered by the OTLP transport before they are exported
+const otlpBatchSize = 100
+
+// validateExporter returns an error if the Config specifies an unknown span exporter or, when
+// exporting with OTLP, an unsupported OTLP protocol
+func (c Config) validateExporter() error {
+	switch c.Exporter {
+	case "", ExporterJaeger:
+		return nil
+	case ExporterOTLP:
+		return c.validateOTLPProtocol()
+	}
+	return fmt.Errorf("unknown span exporter %q, expected %s or %s", c.Exporter, ExporterJaeger, ExporterOTLP)
+}
+
+// validateOTLPProtocol returns an error if the configured OTLP protocol is not supported. An empty
+// protocol defaults to OTLPProtocolHTTP.
+func (c Config) validateOTLPProtocol() error {
+	switch c.OTLPProtocol {
+	case "", OTLPProtocolHTTP:
+		return nil
+	case "grpc":
+		return fmt.Errorf("unsupported otlp protocol grpc, only %s is supported", OTLPProtocolHTTP)
+	}
+	return fmt.Errorf("unknown otlp protocol %q, only %s is supported", c.OTLPProtocol, OTLPProtocolHTTP)
+}
+
+// otlpEndpoint validates the configured OTLP protocol and endpoint, returning the endpoint URL
+func (c Config) otlpEndpoint() (*url.URL, error) {
+	if err := c.validateOTLPProtocol(); err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(c.OTLPEndpoint)
+	if err != nil || c.OTLPEndpoint == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %q", c.OTLPEndpoint)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("otlp endpoint %s must use the http or https scheme for the %s protocol", c.OTLPEndpoint, OTLPProtocolHTTP)
+	}
+	return endpoint, nil
+}
+
+// newOTLPTransport creates the transport used to export spans with OTLP
+func (c Config) newOTLPTransport() (jaeger.Transport, error) {
+	endpoint, err := c.otlpEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return &otlpHTTPTransport{
+		endpoint: endpoint.String(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// otlpHTTPTransport exports spans to an OpenTelemetry collector using the JSON encoding of
+// OTLP/HTTP. The service name and process tags of the tracer are exported as resource attributes.
+type otlpHTTPTransport struct {
+	endpoint string
+	client   *http.Client
+	mutex    sync.Mutex
+	resource []otlpAttribute
+	spans    []otlpSpan
+}
+
+// The following types are the subset of the OTLP/HTTP JSON trace request used by the transport
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Events            []otlpEvent     `json:"events,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string          `json:"timeUnixNano"`
+		Name         string          `json:"name"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	}
+	otlpStatus struct {
+		Code int `json:"code"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BytesValue  []byte   `json:"bytesValue,omitempty"`
+	}
+)
+
+// OTLP span kinds and status codes
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpSpanKindProducer = 4
+	otlpSpanKindConsumer = 5
+	otlpStatusCodeError  = 2
+)
+
+// otlpAttributes converts jaeger thrift tags into OTLP attributes
+func otlpAttributes(tags []*j.Tag) []otlpAttribute {
+	attributes := make([]otlpAttribute, 0, len(tags))
+	for _, tag := range tags {
+		attribute := otlpAttribute{Key: tag.Key}
+		switch tag.VType {
+		case j.TagType_STRING:
+			attribute.Value.StringValue = tag.VStr
+		case j.TagType_BOOL:
+			attribute.Value.BoolValue = tag.VBool
+		case j.TagType_LONG:
+			intValue := strconv.FormatInt(tag.GetVLong(), 10)
+			attribute.Value.IntValue = &intValue
+		case j.TagType_DOUBLE:
+			attribute.Value.DoubleValue = tag.VDouble
+		case j.TagType_BINARY:
+			attribute.Value.BytesValue = tag.VBinary
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes
+}
+
+// microsToNanos converts a timestamp in microseconds into an OTLP nanosecond timestamp
+func microsToNanos(micros int64) string {
+	return strconv.FormatInt(micros*int64(time.Microsecond), 10)
+}
+
+// newOTLPResource converts the tracer process of a jaeger span into OTLP resource attributes
+func newOTLPResource(span *jaeger.Span) []otlpAttribute {
+	process := jaeger.BuildJaegerProcessThrift(span)
+	serviceName := process.ServiceName
+	return append(
+		[]otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: &serviceName}}},
+		otlpAttributes(process.Tags)...,
+	)
+}
+
+// newOTLPSpan converts a finished jaeger span into an OTLP span
+func newOTLPSpan(span *jaeger.Span) otlpSpan {
+	thriftSpan := jaeger.BuildJaegerThrift(span)
+	converted := otlpSpan{
+		TraceID:           fmt.Sprintf("%016x%016x", uint64(thriftSpan.TraceIdHigh), uint64(thriftSpan.TraceIdLow)),
+		SpanID:            fmt.Sprintf("%016x", uint64(thriftSpan.SpanId)),
+		Name:              thriftSpan.OperationName,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: microsToNanos(thriftSpan.StartTime),
+		EndTimeUnixNano:   microsToNanos(thriftSpan.StartTime + thriftSpan.Duration),
+		Attributes:        otlpAttributes(thriftSpan.Tags),
+	}
+	if thriftSpan.ParentSpanId != 0 {
+		converted.ParentSpanID = fmt.Sprintf("%016x", uint64(thriftSpan.ParentSpanId))
+	}
+	for _, tag := range thriftSpan.Tags {
+		switch {
+		case tag.Key == "span.kind":
+			switch tag.GetVStr() {
+			case "server":
+				converted.Kind = otlpSpanKindServer
+			case "client":
+				converted.Kind = otlpSpanKindClient
+			case "producer":
+				converted.Kind = otlpSpanKindProducer
+			case "consumer":
+				converted.Kind = otlpSpanKindConsumer
+			}
+		case tag.Key == "error" && tag.GetVBool():
+			converted.Status.Code = otlpStatusCodeError
+		}
+	}
+	for _, log := range thriftSpan.Logs {
+		event := otlpEvent{TimeUnixNano: microsToNanos(log.Timestamp), Name: "log"}
+		for _, attribute := range otlpAttributes(log.Fields) {
+			if attribute.Key == "event" && attribute.Value.StringValue != nil {
+				event.Name = *attribute.Value.StringValue
+				continue
+			}
+			event.Attributes = append(event.Attributes, attribute)
+		}
+		converted.Events = append(converted.Events, event)
+	}
+	return converted
+}
+
+// Append implements the jaeger Transport interface, buffering the span and exporting the buffered
+// spans once the batch size is reached
+func (t *otlpHTTPTransport) Append(span *jaeger.Span) (int, error) {
+	t.mutex.Lock()
+	if t.resource == nil {
+		t.resource = newOTLPResource(span)
+	}
+	t.spans = append(t.spans, newOTLPSpan(span))
+	full := len(t.spans) >= otlpBatchSize
+	t.mutex.Unlock()
+	if full {
+		return t.Flush()
+	}
+	return 0, nil
+}
+
+// Flush implements the jaeger Transport interface, exporting all buffered spans
+func (t *otlpHTTPTransport) Flush() (int, error) {
+	t.mutex.Lock()
+	spans, resource := t.spans, t.resource
+	t.spans = nil
+	t.mutex.Unlock()
+	if len(spans) == 0 {
+		return 0, nil
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/spothero/tools/tracing"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return len(spans), fmt.Errorf("failed to encode otlp spans: %w", err)
+	}
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return len(spans), fmt.Errorf("failed to export otlp spans: %w", err)
+	}
+	// The body is drained so that the connection may be reused for the next export
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return len(spans), fmt.Errorf("failed to export otlp spans: collector responded with %s", resp.Status)
+	}
+	return len(spans), nil
+}
+
+// Close implements the jaeger Transport interface, exporting all buffered spans
+func (t *otlpHTTPTransport) Close() error {
+	_, err := t.Flush()
+	return err
+}
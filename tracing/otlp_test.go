@@ -0,0 +1,161 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOTLPTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		expectErr bool
+	}{
+		{"the http protocol builds the http exporter", Config{OTLPProtocol: "http", OTLPEndpoint: "http://collector:4318/v1/traces"}, false},
+		{"the protocol defaults to http", Config{OTLPEndpoint: "https://collector:4318/v1/traces"}, false},
+		{"http endpoints must use an http scheme", Config{OTLPProtocol: "http", OTLPEndpoint: "grpc://collector:4317"}, true},
+		{"missing endpoints result in an error", Config{OTLPProtocol: "http"}, true},
+		{"the grpc protocol is not supported", Config{OTLPProtocol: "grpc", OTLPEndpoint: "http://collector:4317"}, true},
+		{"unknown protocols result in an error", Config{OTLPProtocol: "udp", OTLPEndpoint: "http://collector:4318"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport, err := test.config.newOTLPTransport()
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			httpTransport, ok := transport.(*otlpHTTPTransport)
+			require.True(t, ok)
+			assert.Equal(t, test.config.OTLPEndpoint, httpTransport.endpoint)
+		})
+	}
+}
+
+func TestNewTracerOTLPExporter(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var request otlpRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests <- request
+	}))
+	defer collector.Close()
+
+	tracer, err := Config{
+		Enabled:               true,
+		SamplerParam:          1,
+		ServiceName:           "test",
+		ReporterMaxQueueSize:  100,
+		ReporterFlushInterval: time.Second,
+		Exporter:              ExporterOTLP,
+		OTLPProtocol:          OTLPProtocolHTTP,
+		Version:               "1.2.3",
+		OTLPEndpoint:          collector.URL + "/v1/traces",
+		DisableGlobalTracer:   true,
+	}.NewTracer()
+	require.NoError(t, err)
+	parent := tracer.Tracer.StartSpan("parent", opentracing.Tag{Key: "span.kind", Value: "server"})
+	child := tracer.Tracer.StartSpan("child", opentracing.ChildOf(parent.Context()))
+	child.SetTag("error", true).SetTag("count", 3)
+	child.LogKV("event", "retrying", "attempt", 2)
+	child.Finish()
+	parent.Finish()
+	// Closing the tracer flushes all pending spans to the collector
+	require.NoError(t, tracer.Closer.Close())
+
+	var request otlpRequest
+	select {
+	case request = <-requests:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no spans were exported")
+	}
+	require.Len(t, request.ResourceSpans, 1)
+	resource := make(map[string]otlpValue)
+	for _, attribute := range request.ResourceSpans[0].Resource.Attributes {
+		resource[attribute.Key] = attribute.Value
+	}
+	require.NotNil(t, resource["service.name"].StringValue)
+	assert.Equal(t, "test", *resource["service.name"].StringValue)
+	require.NotNil(t, resource["version"].StringValue)
+	assert.Equal(t, "1.2.3", *resource["version"].StringValue)
+	require.Len(t, request.ResourceSpans[0].ScopeSpans, 1)
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	childSpan, parentSpan := spans[0], spans[1]
+	assert.Equal(t, "child", childSpan.Name)
+	assert.Len(t, childSpan.TraceID, 32)
+	assert.Len(t, childSpan.SpanID, 16)
+	assert.Equal(t, parentSpan.TraceID, childSpan.TraceID)
+	assert.Equal(t, parentSpan.SpanID, childSpan.ParentSpanID)
+	assert.Equal(t, otlpSpanKindInternal, childSpan.Kind)
+	assert.Equal(t, otlpStatusCodeError, childSpan.Status.Code)
+	require.Len(t, childSpan.Events, 1)
+	assert.Equal(t, "retrying", childSpan.Events[0].Name)
+	attributes := make(map[string]otlpValue)
+	for _, attribute := range childSpan.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+	assert.Equal(t, "3", *attributes["count"].IntValue)
+	assert.True(t, *attributes["error"].BoolValue)
+
+	assert.Equal(t, "parent", parentSpan.Name)
+	assert.Empty(t, parentSpan.ParentSpanID)
+	assert.Equal(t, otlpSpanKindServer, parentSpan.Kind)
+	assert.Equal(t, 0, parentSpan.Status.Code)
+}
+
+func TestConfigValidateExporter(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		expectErr bool
+	}{
+		{"the exporter defaults to jaeger", Config{}, false},
+		{"the jaeger exporter is valid", Config{Exporter: ExporterJaeger}, false},
+		{"the otlp exporter defaults to the http protocol", Config{Exporter: ExporterOTLP}, false},
+		{"the otlp exporter supports the http protocol", Config{Exporter: ExporterOTLP, OTLPProtocol: "http"}, false},
+		{"the otlp exporter rejects the grpc protocol", Config{Exporter: ExporterOTLP, OTLPProtocol: "grpc"}, true},
+		{"the otlp exporter rejects unknown protocols", Config{Exporter: ExporterOTLP, OTLPProtocol: "udp"}, true},
+		{"unknown exporters are rejected", Config{Exporter: "zipkin"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.validateExporter()
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewTracerUnknownExporter(t *testing.T) {
+	_, err := Config{Enabled: true, ServiceName: "test", Exporter: "zipkin", DisableGlobalTracer: true}.NewTracer()
+	assert.Error(t, err)
+}
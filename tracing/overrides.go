@@ -0,0 +1,159 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SamplingOverrides holds temporary sampling rules which take precedence over the sampling rules
+// and tracer sampler of the HTTP server middleware, allowing operators to raise sampling of a
+// route while debugging without redeploying. Overrides expire after their configured duration.
+// SamplingOverrides are consulted by the middleware only when set with WithSamplingOverrides.
+type SamplingOverrides struct {
+	mutex     sync.RWMutex
+	overrides []samplingOverride
+	now       func() time.Time
+}
+
+// samplingOverride is a sampling rule which applies until it expires
+type samplingOverride struct {
+	SamplingRule
+	expiresAt time.Time
+}
+
+// samplingOverrideStatus is the JSON representation of an active sampling override
+type samplingOverrideStatus struct {
+	Pattern   string    `json:"pattern"`
+	Rate      float64   `json:"rate"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewSamplingOverrides creates an empty set of SamplingOverrides
+func NewSamplingOverrides() *SamplingOverrides {
+	return &SamplingOverrides{now: time.Now}
+}
+
+// Set adds a sampling override for the given rule which applies for the given duration. More
+// recently added overrides take precedence over older overrides. Expired overrides are removed.
+func (so *SamplingOverrides) Set(rule SamplingRule, duration time.Duration) {
+	so.mutex.Lock()
+	defer so.mutex.Unlock()
+	now := so.now()
+	active := so.overrides[:0]
+	for _, override := range so.overrides {
+		if now.Before(override.expiresAt) {
+			active = append(active, override)
+		}
+	}
+	so.overrides = append(active, samplingOverride{SamplingRule: rule, expiresAt: now.Add(duration)})
+}
+
+// Clear removes all sampling overrides
+func (so *SamplingOverrides) Clear() {
+	so.mutex.Lock()
+	defer so.mutex.Unlock()
+	so.overrides = nil
+}
+
+// rate returns the sampling rate of the most recently added unexpired override matching the
+// route, if any. Overrides without a pattern, as with sampling rules, never match.
+func (so *SamplingOverrides) rate(route string) (float64, bool) {
+	so.mutex.RLock()
+	defer so.mutex.RUnlock()
+	now := so.now()
+	for i := len(so.overrides) - 1; i >= 0; i-- {
+		override := so.overrides[i]
+		if override.Pattern != nil && now.Before(override.expiresAt) && override.Pattern.MatchString(route) {
+			return override.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// status returns all unexpired overrides with a pattern
+func (so *SamplingOverrides) status() []samplingOverrideStatus {
+	so.mutex.RLock()
+	defer so.mutex.RUnlock()
+	now := so.now()
+	status := make([]samplingOverrideStatus, 0, len(so.overrides))
+	for _, override := range so.overrides {
+		if override.Pattern != nil && now.Before(override.expiresAt) {
+			status = append(status, samplingOverrideStatus{
+				Pattern:   override.Pattern.String(),
+				Rate:      override.Rate,
+				ExpiresAt: override.expiresAt,
+			})
+		}
+	}
+	return status
+}
+
+// DebugHandler returns an HTTP handler which allows operators to manage the sampling overrides.
+// The handler is never registered automatically and should only be mounted on an internal or
+// otherwise protected route when explicitly enabled. The handler supports the following methods:
+// * GET - Returns the active overrides as JSON
+// * POST - Adds an override from the pattern (default ".*"), rate (default 1), and duration (required, such as "10m") form values
+// * DELETE - Removes all overrides
+func (so *SamplingOverrides) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := so.setFromRequest(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			so.Clear()
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(so.status())
+	})
+}
+
+// setFromRequest adds a sampling override from the form values of the request
+func (so *SamplingOverrides) setFromRequest(r *http.Request) error {
+	pattern := r.FormValue("pattern")
+	if pattern == "" {
+		pattern = ".*"
+	}
+	rate := 1.0
+	if rateStr := r.FormValue("rate"); rateStr != "" {
+		var err error
+		if rate, err = strconv.ParseFloat(rateStr, 64); err != nil {
+			return fmt.Errorf("invalid rate %s: %w", rateStr, err)
+		}
+	}
+	duration, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("invalid duration %q, must be a positive duration such as 10m", r.FormValue("duration"))
+	}
+	rule, err := NewSamplingRule(pattern, rate)
+	if err != nil {
+		return err
+	}
+	so.Set(rule, duration)
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestSamplingOverridesDebugHandler(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	currentTime := time.Unix(0, 0)
+	overrides := NewSamplingOverrides()
+	overrides.now = func() time.Time { return currentTime }
+	debugHandler := overrides.DebugHandler()
+
+	var sampled bool
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+		require.True(t, ok)
+		sampled = spanCtx.IsSampled()
+	})
+	mw := NewHTTPServerMiddleware(WithTracer(tracer), WithSamplingOverrides(overrides))(testHandler)
+	isSampled := func(path string) bool {
+		mw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		return sampled
+	}
+	debugRequest := func(method string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/debug/sampling", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		debugHandler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.False(t, isSampled("/users"))
+
+	// Invalid overrides are rejected
+	assert.Equal(t, http.StatusBadRequest, debugRequest("POST", url.Values{}).Code)
+	assert.Equal(t, http.StatusBadRequest, debugRequest("POST", url.Values{"duration": {"1m"}, "rate": {"2"}}).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, debugRequest("PUT", nil).Code)
+
+	// Force-sample a route
+	recorder := debugRequest("POST", url.Values{"pattern": {"^/users$"}, "duration": {"1m"}})
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var status []samplingOverrideStatus
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&status))
+	require.Len(t, status, 1)
+	assert.Equal(t, "^/users$", status[0].Pattern)
+	assert.Equal(t, 1.0, status[0].Rate)
+	assert.True(t, isSampled("/users"))
+	assert.False(t, isSampled("/orders"))
+
+	// Overrides expire after their duration
+	currentTime = currentTime.Add(2 * time.Minute)
+	assert.False(t, isSampled("/users"))
+
+	// Overrides may be cleared
+	debugRequest("POST", url.Values{"duration": {"1m"}})
+	assert.True(t, isSampled("/orders"))
+	assert.Equal(t, http.StatusOK, debugRequest("DELETE", nil).Code)
+	assert.False(t, isSampled("/orders"))
+	assert.Equal(t, "[]\n", debugRequest("GET", nil).Body.String())
+}
+
+func TestSamplingOverridesSet(t *testing.T) {
+	currentTime := time.Unix(0, 0)
+	overrides := NewSamplingOverrides()
+	overrides.now = func() time.Time { return currentTime }
+	rule, err := NewSamplingRule("^/users$", 1)
+	require.NoError(t, err)
+
+	overrides.Set(rule, time.Minute)
+	overrides.Set(rule, time.Hour)
+	assert.Len(t, overrides.overrides, 2)
+
+	// Expired overrides no longer apply and are removed when the next override is set
+	currentTime = currentTime.Add(2 * time.Minute)
+	overrides.Set(rule, time.Minute)
+	assert.Len(t, overrides.overrides, 2)
+	currentTime = currentTime.Add(2 * time.Hour)
+	_, ok := overrides.rate("/users")
+	assert.False(t, ok)
+	overrides.Set(rule, time.Minute)
+	assert.Len(t, overrides.overrides, 1)
+}
+
+func TestSamplingOverridesNilPattern(t *testing.T) {
+	overrides := NewSamplingOverrides()
+	overrides.Set(SamplingRule{Rate: 1}, time.Hour)
+	_, ok := overrides.rate("/users")
+	assert.False(t, ok)
+	assert.Empty(t, overrides.status())
+}
@@ -0,0 +1,267 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+)
+
+// Propagator injects span contexts into, and extracts span contexts from, the carriers of the
+// OpenTracing HTTPHeaders format. A custom Propagator may be set on the Config to support
+// non-standard propagation headers.
+type Propagator interface {
+	jaeger.Injector
+	jaeger.Extractor
+}
+
+// NewJaegerPropagator returns the default Propagator, which uses the standard jaeger headers
+func NewJaegerPropagator() Propagator {
+	return jaeger.NewHTTPHeaderPropagator((&jaeger.HeadersConfig{}).ApplyDefaults(), *jaeger.NewNullMetrics())
+}
+
+// Datadog propagation headers
+const (
+	datadogTraceIDHeader          = "x-datadog-trace-id"
+	datadogParentIDHeader         = "x-datadog-parent-id"
+	datadogSamplingPriorityHeader = "x-datadog-sampling-priority"
+	datadogBaggageHeaderPrefix    = "ot-baggage-"
+)
+
+// datadogPropagator propagates span contexts using the Datadog headers
+type datadogPropagator struct{}
+
+// NewDatadogPropagator returns a Propagator which uses the Datadog x-datadog-trace-id,
+// x-datadog-parent-id, and x-datadog-sampling-priority headers so that traces may cross services
+// instrumented with Datadog. Datadog IDs are 64-bit decimal integers, so only the low 64 bits of
+// 128-bit jaeger trace IDs are propagated.
+func NewDatadogPropagator() Propagator {
+	return datadogPropagator{}
+}
+
+// Inject implements jaeger.Injector, injecting the span context as Datadog headers
+func (datadogPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	writer.Set(datadogTraceIDHeader, strconv.FormatUint(sc.TraceID().Low, 10))
+	writer.Set(datadogParentIDHeader, strconv.FormatUint(uint64(sc.SpanID()), 10))
+	samplingPriority := "0"
+	if sc.IsSampled() {
+		samplingPriority = "1"
+	}
+	writer.Set(datadogSamplingPriorityHeader, samplingPriority)
+	sc.ForeachBaggageItem(func(k, v string) bool {
+		writer.Set(datadogBaggageHeaderPrefix+k, url.QueryEscape(v))
+		return true
+	})
+	return nil
+}
+
+// Extract implements jaeger.Extractor, extracting the span context from Datadog headers
+func (datadogPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+	var traceID, parentID uint64
+	sampled := false
+	var baggage map[string]string
+	err := reader.ForeachKey(func(rawKey, value string) error {
+		var err error
+		switch key := strings.ToLower(rawKey); {
+		case key == datadogTraceIDHeader:
+			traceID, err = strconv.ParseUint(value, 10, 64)
+		case key == datadogParentIDHeader:
+			parentID, err = strconv.ParseUint(value, 10, 64)
+		case key == datadogSamplingPriorityHeader:
+			var priority int
+			priority, err = strconv.Atoi(value)
+			sampled = priority > 0
+		case strings.HasPrefix(key, datadogBaggageHeaderPrefix):
+			if baggage == nil {
+				baggage = make(map[string]string)
+			}
+			if unescaped, err := url.QueryUnescape(value); err == nil {
+				value = unescaped
+			}
+			baggage[strings.TrimPrefix(key, datadogBaggageHeaderPrefix)] = value
+		}
+		return err
+	})
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	if traceID == 0 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+	return jaeger.NewSpanContext(jaeger.TraceID{Low: traceID}, jaeger.SpanID(parentID), 0, sampled, baggage), nil
+}
+
+// gcpTraceContextHeader is the Google Cloud trace propagation header
+const gcpTraceContextHeader = "x-cloud-trace-context"
+
+// gcpPropagator propagates span contexts using the Google Cloud X-Cloud-Trace-Context header
+type gcpPropagator struct{}
+
+// NewGCPPropagator returns a Propagator which uses the X-Cloud-Trace-Context header, in the format
+// TRACE_ID/SPAN_ID;o=OPTIONS, so that traces started by Google Cloud load balancers are continued.
+// The 128-bit hexadecimal trace ID maps directly onto jaeger trace IDs and the decimal span ID
+// onto jaeger span IDs. Requests without the o=1 option are treated as not sampled. The header
+// cannot carry baggage, so baggage is not propagated.
+func NewGCPPropagator() Propagator {
+	return gcpPropagator{}
+}
+
+// Inject implements jaeger.Injector, injecting the span context as the X-Cloud-Trace-Context header
+func (gcpPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	writer, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	options := 0
+	if sc.IsSampled() {
+		options = 1
+	}
+	traceID := sc.TraceID()
+	writer.Set(gcpTraceContextHeader, fmt.Sprintf("%016x%016x/%d;o=%d", traceID.High, traceID.Low, uint64(sc.SpanID()), options))
+	return nil
+}
+
+// Extract implements jaeger.Extractor, extracting the span context from the X-Cloud-Trace-Context
+// header
+func (gcpPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	reader, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+	var value string
+	_ = reader.ForeachKey(func(key, val string) error {
+		if strings.ToLower(key) == gcpTraceContextHeader {
+			value = val
+		}
+		return nil
+	})
+	if value == "" {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+	ids := strings.SplitN(value, ";", 2)
+	parts := strings.SplitN(ids[0], "/", 2)
+	if len(parts) != 2 {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	traceID, err := jaeger.TraceIDFromString(parts[0])
+	if err != nil || !traceID.IsValid() {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	sampled := len(ids) == 2 && ids[1] == "o=1"
+	return jaeger.NewSpanContext(traceID, jaeger.SpanID(spanID), 0, sampled, nil), nil
+}
+
+// InjectQueryParam injects the span context into the given query parameter of the URL as a
+// base64-encoded text map. This allows trace context to be propagated through flows which
+// cannot carry headers, such as redirects. See WithQueryParamExtraction for extraction.
+func InjectQueryParam(u *url.URL, param string, span opentracing.Span) error {
+	encoded, err := encodeSpanContext(span.Tracer(), span.Context())
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set(param, encoded)
+	u.RawQuery = query.Encode()
+	return nil
+}
+
+// encodeSpanContext encodes the span context as a base64-encoded text map
+func encodeSpanContext(tracer opentracing.Tracer, sc opentracing.SpanContext) (string, error) {
+	carrier := opentracing.TextMapCarrier{}
+	if err := tracer.Inject(sc, opentracing.TextMap, carrier); err != nil {
+		return "", fmt.Errorf("failed to inject span context: %w", err)
+	}
+	encoded, err := json.Marshal(carrier)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode span context: %w", err)
+	}
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+// decodeSpanContext decodes a span context from a base64-encoded text map
+func decodeSpanContext(tracer opentracing.Tracer, value string) (opentracing.SpanContext, error) {
+	decoded, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode span context: %w", err)
+	}
+	carrier := opentracing.TextMapCarrier{}
+	if err := json.Unmarshal(decoded, &carrier); err != nil {
+		return nil, fmt.Errorf("failed to decode span context: %w", err)
+	}
+	return tracer.Extract(opentracing.TextMap, carrier)
+}
+
+// extractQueryParam extracts a span context from the given base64-encoded text map query
+// parameter of the request
+func extractQueryParam(tracer opentracing.Tracer, r *http.Request, param string) (opentracing.SpanContext, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+	return decodeSpanContext(tracer, value)
+}
+
+// remoteSpanCtxKeyType is the type used to uniquely place deserialized span contexts in contexts
+type remoteSpanCtxKeyType int
+
+// remoteSpanCtxKey is the key into any context.Context which maps to a deserialized span context
+const remoteSpanCtxKey remoteSpanCtxKeyType = iota
+
+// SerializeContext encodes the span context of the span on the given context as a compact token,
+// a base64-encoded text map, using the tracer which started the span. This allows traces to be
+// continued through systems which can only carry strings, such as job queues: producers store
+// the token in the job payload and consumers restore it with DeserializeContext. An error is
+// returned if the context does not carry a span.
+func SerializeContext(ctx context.Context) (string, error) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return "", opentracing.ErrSpanContextNotFound
+	}
+	return encodeSpanContext(span.Tracer(), span.Context())
+}
+
+// DeserializeContext decodes a token produced by SerializeContext and returns a context carrying
+// the decoded span context. The token is decoded with the tracer of the span on the given
+// context, otherwise the tracer set with ContextWithTracer, or the OpenTracing global tracer. The
+// first span started from the returned context with StartSpanFromContext continues the serialized
+// trace, referencing the serialized span with a FollowsFrom relationship.
+func DeserializeContext(ctx context.Context, token string) (context.Context, error) {
+	sc, err := decodeSpanContext(contextTracer(ctx), token)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, remoteSpanCtxKey, sc), nil
+}
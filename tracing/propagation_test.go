@@ -0,0 +1,300 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestQueryParamPropagation(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	upstreamSpan := tracer.StartSpan("upstream")
+	defer upstreamSpan.Finish()
+	upstreamSpanCtx, ok := upstreamSpan.Context().(jaeger.SpanContext)
+	require.True(t, ok)
+
+	u, err := url.Parse("http://example.com/callback?code=abc")
+	require.NoError(t, err)
+	require.NoError(t, InjectQueryParam(u, "trace", upstreamSpan))
+	assert.Equal(t, "abc", u.Query().Get("code"))
+	assert.NotEmpty(t, u.Query().Get("trace"))
+
+	tests := []struct {
+		name         string
+		opts         []MiddlewareOption
+		url          string
+		expectParent bool
+	}{
+		{
+			"query param extraction is disabled by default",
+			nil,
+			u.String(),
+			false,
+		},
+		{
+			"span context is extracted from the configured query param",
+			[]MiddlewareOption{WithQueryParamExtraction("trace")},
+			u.String(),
+			true,
+		},
+		{
+			"invalid query params start a new trace",
+			[]MiddlewareOption{WithQueryParamExtraction("trace")},
+			"http://example.com/callback?trace=not-base64!",
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var traceID jaeger.TraceID
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+				require.True(t, ok)
+				traceID = spanCtx.TraceID()
+			})
+			mw := NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.url, nil))
+			if test.expectParent {
+				assert.Equal(t, upstreamSpanCtx.TraceID(), traceID)
+			} else {
+				assert.NotEqual(t, upstreamSpanCtx.TraceID(), traceID)
+			}
+		})
+	}
+}
+
+// customPropagator propagates span contexts using separate Trace, Span, and Sampled headers
+type customPropagator struct{}
+
+func (customPropagator) Inject(sc jaeger.SpanContext, carrier interface{}) error {
+	headers, ok := carrier.(opentracing.HTTPHeadersCarrier)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	headers.Set("Trace", sc.TraceID().String())
+	headers.Set("Span", sc.SpanID().String())
+	headers.Set("Sampled", strconv.FormatBool(sc.IsSampled()))
+	return nil
+}
+
+func (customPropagator) Extract(carrier interface{}) (jaeger.SpanContext, error) {
+	headers, ok := carrier.(opentracing.HTTPHeadersCarrier)
+	if !ok {
+		return jaeger.SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+	header := http.Header(headers)
+	if header.Get("Trace") == "" {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+	traceID, err := jaeger.TraceIDFromString(header.Get("Trace"))
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	spanID, err := jaeger.SpanIDFromString(header.Get("Span"))
+	if err != nil {
+		return jaeger.SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	sampled, _ := strconv.ParseBool(header.Get("Sampled"))
+	return jaeger.NewSpanContext(traceID, spanID, 0, sampled, nil), nil
+}
+
+func TestPropagator(t *testing.T) {
+	tests := []struct {
+		name            string
+		propagator      Propagator
+		expectedHeaders []string
+	}{
+		{
+			"the default propagator uses the jaeger headers",
+			NewJaegerPropagator(),
+			[]string{"Uber-Trace-Id"},
+		},
+		{
+			"custom propagators use custom headers",
+			customPropagator{},
+			[]string{"Trace", "Span", "Sampled"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, err := Config{
+				Enabled:             true,
+				ServiceName:         "test",
+				AgentHost:           "localhost",
+				AgentPort:           6831,
+				DisableGlobalTracer: true,
+				Propagator:          test.propagator,
+			}.NewTracer()
+			require.NoError(t, err)
+			defer tracer.Closer.Close()
+
+			span := tracer.Tracer.StartSpan("test")
+			defer span.Finish()
+			header := http.Header{}
+			require.NoError(t, tracer.Tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header)))
+			for _, expectedHeader := range test.expectedHeaders {
+				assert.NotEmpty(t, header.Get(expectedHeader))
+			}
+
+			extracted, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+			require.NoError(t, err)
+			assert.Equal(t, span.Context().(jaeger.SpanContext).TraceID(), extracted.(jaeger.SpanContext).TraceID())
+			assert.Equal(t, span.Context().(jaeger.SpanContext).SpanID(), extracted.(jaeger.SpanContext).SpanID())
+		})
+	}
+}
+
+func TestDatadogPropagator(t *testing.T) {
+	tracer, err := Config{
+		Enabled:             true,
+		ServiceName:         "test",
+		AgentHost:           "localhost",
+		AgentPort:           6831,
+		DisableGlobalTracer: true,
+		Propagator:          NewDatadogPropagator(),
+	}.NewTracer()
+	require.NoError(t, err)
+	defer tracer.Closer.Close()
+
+	t.Run("missing headers return an error", func(t *testing.T) {
+		_, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(http.Header{}))
+		assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+	})
+
+	t.Run("corrupt headers return an error", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("X-Datadog-Trace-Id", "not-a-number")
+		_, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+		assert.Equal(t, opentracing.ErrSpanContextCorrupted, err)
+	})
+
+	t.Run("datadog headers round trip", func(t *testing.T) {
+		inbound := http.Header{}
+		inbound.Set("X-Datadog-Trace-Id", "1234567890")
+		inbound.Set("X-Datadog-Parent-Id", "987654321")
+		inbound.Set("X-Datadog-Sampling-Priority", "1")
+		inbound.Set("Ot-Baggage-User", "test user")
+		wireContext, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(inbound))
+		require.NoError(t, err)
+		extracted := wireContext.(jaeger.SpanContext)
+		assert.Equal(t, jaeger.TraceID{Low: 1234567890}, extracted.TraceID())
+		assert.Equal(t, jaeger.SpanID(987654321), extracted.SpanID())
+		assert.True(t, extracted.IsSampled())
+
+		span := tracer.Tracer.StartSpan("test", opentracing.ChildOf(wireContext))
+		defer span.Finish()
+		assert.Equal(t, "test user", span.BaggageItem("user"))
+		outbound := http.Header{}
+		require.NoError(t, tracer.Tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(outbound)))
+		assert.Equal(t, "1234567890", outbound.Get("X-Datadog-Trace-Id"))
+		assert.Equal(t, strconv.FormatUint(uint64(span.Context().(jaeger.SpanContext).SpanID()), 10), outbound.Get("X-Datadog-Parent-Id"))
+		assert.Equal(t, "1", outbound.Get("X-Datadog-Sampling-Priority"))
+		assert.Equal(t, "test+user", outbound.Get("Ot-Baggage-User"))
+	})
+}
+
+func TestGCPPropagator(t *testing.T) {
+	tracer, err := Config{
+		Enabled:             true,
+		ServiceName:         "test",
+		AgentHost:           "localhost",
+		AgentPort:           6831,
+		DisableGlobalTracer: true,
+		Propagator:          NewGCPPropagator(),
+	}.NewTracer()
+	require.NoError(t, err)
+	defer tracer.Closer.Close()
+
+	t.Run("missing headers return an error", func(t *testing.T) {
+		_, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(http.Header{}))
+		assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+	})
+
+	t.Run("corrupt headers return an error", func(t *testing.T) {
+		for _, value := range []string{"not-a-trace", "not-hex/1;o=1", "105445aa7843bc8bf206b12000100000/not-a-number"} {
+			header := http.Header{}
+			header.Set("X-Cloud-Trace-Context", value)
+			_, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+			assert.Equal(t, opentracing.ErrSpanContextCorrupted, err, value)
+		}
+	})
+
+	t.Run("unsampled headers are not sampled", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1")
+		wireContext, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(header))
+		require.NoError(t, err)
+		assert.False(t, wireContext.(jaeger.SpanContext).IsSampled())
+	})
+
+	t.Run("gcp headers round trip", func(t *testing.T) {
+		inbound := http.Header{}
+		inbound.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+		wireContext, err := tracer.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(inbound))
+		require.NoError(t, err)
+		extracted := wireContext.(jaeger.SpanContext)
+		assert.Equal(t, jaeger.TraceID{High: 0x105445aa7843bc8b, Low: 0xf206b12000100000}, extracted.TraceID())
+		assert.Equal(t, jaeger.SpanID(1), extracted.SpanID())
+		assert.True(t, extracted.IsSampled())
+
+		span := tracer.Tracer.StartSpan("test", opentracing.ChildOf(wireContext))
+		defer span.Finish()
+		outbound := http.Header{}
+		require.NoError(t, tracer.Tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(outbound)))
+		expected := fmt.Sprintf("105445aa7843bc8bf206b12000100000/%d;o=1", uint64(span.Context().(jaeger.SpanContext).SpanID()))
+		assert.Equal(t, expected, outbound.Get("X-Cloud-Trace-Context"))
+	})
+}
+
+func TestSerializeContext(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	_, err := SerializeContext(context.Background())
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+	_, err = DeserializeContext(context.Background(), "not a token")
+	assert.Error(t, err)
+
+	producer, producerCtx := opentracing.StartSpanFromContext(context.Background(), "producer")
+	producer.SetBaggageItem("job", "cleanup")
+	token, err := SerializeContext(producerCtx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	producer.Finish()
+
+	consumerCtx, err := DeserializeContext(context.Background(), token)
+	require.NoError(t, err)
+	consumer, _ := StartSpanFromContext(consumerCtx, "consumer")
+	defer consumer.Finish()
+	producerSpanCtx := producer.Context().(jaeger.SpanContext)
+	consumerSpanCtx := consumer.Context().(jaeger.SpanContext)
+	assert.Equal(t, producerSpanCtx.TraceID(), consumerSpanCtx.TraceID())
+	assert.Equal(t, producerSpanCtx.SpanID(), consumerSpanCtx.ParentID())
+	assert.Equal(t, "cleanup", consumer.BaggageItem("job"))
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// proxyTransport names the client span of the tracing RoundTripper as a proxy span and injects
+// its trace context into requests proxied by an httputil.ReverseProxy
+type proxyTransport struct {
+	base http.RoundTripper
+}
+
+// NewProxyTransport returns a RoundTripper for use as the Transport of an httputil.ReverseProxy,
+// making the proxy hop visible in traces. Proxied requests are traced by the tracing RoundTripper,
+// whose client span, a child of the span on the inbound request context, is named "proxy", and the
+// trace context of the proxy span is injected into the proxied request with TraceOutbound so that
+// the backend continues the trace. Attach the tracing HTTP server middleware in front of the proxy
+// so that the inbound trace is extracted. If base is nil, the net/http DefaultTransport is used.
+//
+// Proxy spans are tagged with the tags of the RoundTripper client spans, as well as:
+// * component - Always set to "proxy"
+// * peer.hostname - The host of the backend
+func NewProxyTransport(base http.RoundTripper) http.RoundTripper {
+	return newProxyTransport(base, nil)
+}
+
+// newProxyTransport returns a proxy transport starting proxy spans without a parent span with the
+// given tracer, or the OpenTracing global tracer if nil
+func newProxyTransport(base http.RoundTripper, tracer opentracing.Tracer) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return RoundTripper{RoundTripper: proxyTransport{base: base}, Tracer: tracer}
+}
+
+// RoundTrip implements http.RoundTripper, proxying the request traced by the tracing RoundTripper
+func (pt proxyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	span := opentracing.SpanFromContext(r.Context())
+	if span == nil {
+		return pt.base.RoundTrip(r)
+	}
+	span.SetOperationName("proxy")
+	ext.SpanKindRPCClient.Set(span)
+	span.SetTag("component", "proxy").SetTag(string(ext.PeerHostname), r.URL.Hostname())
+
+	// RoundTrippers must not modify the request, so the trace context is injected into a clone
+	proxied := r.Clone(r.Context())
+	if err := TraceOutbound(proxied, span); err != nil {
+		span.LogKV("event", "trace context injection failed", "error", err.Error())
+	}
+	return pt.base.RoundTrip(proxied)
+}
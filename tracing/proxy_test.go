@@ -0,0 +1,76 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+func TestNewProxyTransport(t *testing.T) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	// The backend records the trace context it receives from the proxy
+	var backendCtx opentracing.SpanContext
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		backendCtx, err = tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		assert.NoError(t, err)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	proxy := httputil.NewSingleHostReverseProxy(backendURL)
+	proxy.Transport = NewProxyTransport(nil)
+	recorder := httptest.NewRecorder()
+	NewHTTPServerMiddleware(WithTracer(tracer))(proxy).ServeHTTP(recorder, httptest.NewRequest("GET", "/orders", nil))
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+	spans := reporter.GetSpans()
+	require.Len(t, spans, 2)
+	proxySpan := spans[0].(*jaeger.Span)
+	proxySpanCtx := proxySpan.Context().(jaeger.SpanContext)
+	serverSpanCtx := spans[1].Context().(jaeger.SpanContext)
+	assert.Equal(t, "proxy", proxySpan.OperationName())
+	assert.Equal(t, serverSpanCtx.SpanID(), proxySpanCtx.ParentID())
+	tags := make(map[string]*j.Tag)
+	for _, tag := range jaeger.BuildJaegerThrift(proxySpan).Tags {
+		tags[tag.Key] = tag
+	}
+	assert.Equal(t, "proxy", *tags["component"].VStr)
+	assert.Equal(t, backendURL.Hostname(), *tags["peer.hostname"].VStr)
+	assert.Equal(t, "202 Accepted", *tags["http.status_code"].VStr)
+	assert.NotContains(t, tags, "error")
+
+	// The backend continues the trace from the proxy span
+	require.NotNil(t, backendCtx)
+	assert.Equal(t, proxySpanCtx.TraceID(), backendCtx.(jaeger.SpanContext).TraceID())
+	assert.Equal(t, proxySpanCtx.SpanID(), backendCtx.(jaeger.SpanContext).SpanID())
+}
@@ -0,0 +1,318 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/thrift"
+	"github.com/uber/jaeger-client-go/thrift-gen/agent"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+	"github.com/uber/jaeger-client-go/utils"
+)
+
+// agentHostPort returns the host:port address of the given agent, using the configured
+// AgentPort if the agent does not specify a port
+func (c Config) agentHostPort(agent string) string {
+	if _, _, err := net.SplitHostPort(agent); err == nil {
+		return agent
+	}
+	return net.JoinHostPort(agent, strconv.Itoa(c.AgentPort))
+}
+
+// agentHostPorts returns the host:port addresses of all agents to which spans are reported
+func (c Config) agentHostPorts() []string {
+	if len(c.AgentHosts) == 0 {
+		return []string{c.agentHostPort(c.AgentHost)}
+	}
+	hostPorts := make([]string, len(c.AgentHosts))
+	for idx, agent := range c.AgentHosts {
+		hostPorts[idx] = c.agentHostPort(agent)
+	}
+	return hostPorts
+}
+
+// usesCustomReporter returns true if the Config requires a reporter which cannot be expressed
+// through the jaeger reporter configuration
+func (c Config) usesCustomReporter() bool {
+	return len(c.AgentHosts) > 0 || c.AgentReconnectInterval > 0 || c.Exporter == ExporterOTLP || c.AgentSocketPath != "" ||
+		c.ReporterMaxPacketSize > 0 || c.SpanBuffer != nil
+}
+
+// minPacketSize is the smallest supported maximum packet size, leaving room for small spans
+// after the batch and process overhead
+const minPacketSize = 512
+
+// validateAgent returns an error if the Config specifies both an agent Unix socket and agent
+// hosts, or an out of range maximum packet size. The default AgentHost of localhost is not
+// considered to be set.
+func (c Config) validateAgent() error {
+	if c.ReporterMaxPacketSize != 0 && (c.ReporterMaxPacketSize < minPacketSize || c.ReporterMaxPacketSize > utils.UDPPacketMaxLength) {
+		return fmt.Errorf(
+			"reporter max packet size %d must be between %d and %d",
+			c.ReporterMaxPacketSize, minPacketSize, utils.UDPPacketMaxLength,
+		)
+	}
+	if c.AgentSocketPath == "" {
+		return nil
+	}
+	if len(c.AgentHosts) > 0 || (c.AgentHost != "" && c.AgentHost != "localhost") || c.AgentReconnectInterval > 0 {
+		return fmt.Errorf("only one of the agent socket path or agent hosts may be configured")
+	}
+	return nil
+}
+
+// newTransport creates the UDP transport for the agent at the given address. If an
+// AgentReconnectInterval is configured, the transport periodically re-resolves the agent address.
+func (c Config) newTransport(hostPort string, logger jaeger.Logger) (jaeger.Transport, error) {
+	if c.AgentReconnectInterval > 0 {
+		return newReconnectingTransport(hostPort, c.AgentReconnectInterval, c.ReporterMaxPacketSize, logger)
+	}
+	return jaeger.NewUDPTransport(hostPort, c.ReporterMaxPacketSize)
+}
+
+// newRemoteReporter creates a reporter which reports spans through the given transport
+func (c Config) newRemoteReporter(transport jaeger.Transport, logger jaeger.Logger) jaeger.Reporter {
+	return jaeger.NewRemoteReporter(
+		transport,
+		jaeger.ReporterOptions.QueueSize(c.ReporterMaxQueueSize),
+		jaeger.ReporterOptions.BufferFlushInterval(c.ReporterFlushInterval),
+		jaeger.ReporterOptions.Logger(logger),
+	)
+}
+
+// newReporter creates a reporter which reports every span to each of the configured agents, or
+// to the OTLP endpoint if the OTLP exporter is configured, as well as to the span buffer, if any
+func (c Config) newReporter(logger jaeger.Logger) (jaeger.Reporter, error) {
+	var reporters []jaeger.Reporter
+	if c.Exporter == ExporterOTLP {
+		transport, err := c.newOTLPTransport()
+		if err != nil {
+			return nil, fmt.Errorf("could not create otlp transport: %w", err)
+		}
+		reporters = append(reporters, c.newRemoteReporter(transport, logger))
+	} else if c.AgentSocketPath != "" {
+		transport, err := newSocketTransport(c.AgentSocketPath, c.ReporterMaxPacketSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not create transport for agent socket %s: %w", c.AgentSocketPath, err)
+		}
+		reporters = append(reporters, c.newRemoteReporter(transport, logger))
+	} else {
+		for _, hostPort := range c.agentHostPorts() {
+			transport, err := c.newTransport(hostPort, logger)
+			if err != nil {
+				return nil, fmt.Errorf("could not create transport for agent %s: %w", hostPort, err)
+			}
+			reporters = append(reporters, c.newRemoteReporter(transport, logger))
+		}
+	}
+	if c.SpanBuffer != nil {
+		reporters = append(reporters, c.SpanBuffer)
+	}
+	if c.ReporterLogSpans {
+		reporters = append(reporters, jaeger.NewLoggingReporter(logger))
+	}
+	return jaeger.NewCompositeReporter(reporters...), nil
+}
+
+// reconnectingTransport is a UDP transport which periodically re-resolves the agent address and
+// reconnects if the address has changed, for example after the agent has been rescheduled.
+// Reconnection only happens after a flush, so no buffered spans are lost when reconnecting.
+type reconnectingTransport struct {
+	hostPort      string
+	interval      time.Duration
+	maxPacketSize int
+	logger        jaeger.Logger
+	transport     jaeger.Transport
+	resolvedAddr  string
+	lastResolved  time.Time
+	now           func() time.Time
+	resolve       func(hostPort string) (string, error)
+}
+
+// newReconnectingTransport creates a new reconnectingTransport connected to the agent at the
+// given address. If maxPacketSize is 0, the jaeger default packet size is used.
+func newReconnectingTransport(hostPort string, interval time.Duration, maxPacketSize int, logger jaeger.Logger) (*reconnectingTransport, error) {
+	rt := &reconnectingTransport{
+		hostPort:      hostPort,
+		interval:      interval,
+		maxPacketSize: maxPacketSize,
+		logger:        logger,
+		now:           time.Now,
+		resolve:       resolveUDPAddr,
+	}
+	if err := rt.connect(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// resolveUDPAddr resolves the given host:port into an IP address and port
+func resolveUDPAddr(hostPort string) (string, error) {
+	addr, err := net.ResolveUDPAddr("udp", hostPort)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// connect resolves the agent address and replaces the underlying transport if the address has
+// changed since the last connection
+func (rt *reconnectingTransport) connect() error {
+	rt.lastResolved = rt.now()
+	addr, err := rt.resolve(rt.hostPort)
+	if err != nil {
+		return fmt.Errorf("could not resolve agent address %s: %w", rt.hostPort, err)
+	}
+	if rt.transport != nil && addr == rt.resolvedAddr {
+		return nil
+	}
+	transport, err := jaeger.NewUDPTransport(addr, rt.maxPacketSize)
+	if err != nil {
+		return err
+	}
+	if rt.transport != nil {
+		_ = rt.transport.Close()
+	}
+	rt.transport = transport
+	rt.resolvedAddr = addr
+	return nil
+}
+
+// Append implements the jaeger Transport interface
+func (rt *reconnectingTransport) Append(span *jaeger.Span) (int, error) {
+	return rt.transport.Append(span)
+}
+
+// Flush implements the jaeger Transport interface. After flushing, the agent address is
+// re-resolved if the reconnect interval has elapsed.
+func (rt *reconnectingTransport) Flush() (int, error) {
+	n, err := rt.transport.Flush()
+	if rt.now().Sub(rt.lastResolved) >= rt.interval {
+		if connectErr := rt.connect(); connectErr != nil {
+			rt.logger.Error(connectErr.Error())
+		}
+	}
+	return n, err
+}
+
+// Close implements the jaeger Transport interface
+func (rt *reconnectingTransport) Close() error {
+	return rt.transport.Close()
+}
+
+// socketTransport reports spans to an agent listening on a Unix datagram socket. Spans are sent
+// as thrift-encoded batches, exactly as they are sent to agents over UDP.
+type socketTransport struct {
+	conn           net.Conn
+	client         *agent.AgentClient
+	maxPacketSize  int
+	packetBuffer   *thrift.TMemoryBuffer // buffer of the encoded batch sent to the agent
+	sizeBuffer     *thrift.TMemoryBuffer // buffer used to calculate the encoded size of a span
+	sizeProtocol   thrift.TProtocol
+	process        *j.Process
+	processSize    int
+	spans          []*j.Span
+	spanBufferSize int
+}
+
+// socketBatchOverhead is the number of bytes of a datagram used by the batch envelope, matching
+// the overhead used by the jaeger UDP transport
+const socketBatchOverhead = 30
+
+// errSpanTooLarge is returned when a span cannot fit within a single datagram
+var errSpanTooLarge = errors.New("span is too large")
+
+// newSocketTransport creates a transport which reports spans to the agent listening on the Unix
+// datagram socket at the given path. If maxPacketSize is 0, the UDP packet size limit is used.
+func newSocketTransport(path string, maxPacketSize int) (*socketTransport, error) {
+	if maxPacketSize == 0 {
+		maxPacketSize = utils.UDPPacketMaxLength
+	}
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	protocolFactory := thrift.NewTCompactProtocolFactory()
+	packetBuffer := thrift.NewTMemoryBufferLen(maxPacketSize)
+	sizeBuffer := thrift.NewTMemoryBufferLen(maxPacketSize)
+	return &socketTransport{
+		conn:          conn,
+		client:        agent.NewAgentClientFactory(packetBuffer, protocolFactory),
+		maxPacketSize: maxPacketSize,
+		packetBuffer:  packetBuffer,
+		sizeBuffer:    sizeBuffer,
+		sizeProtocol:  protocolFactory.GetProtocol(sizeBuffer),
+	}, nil
+}
+
+// encodedSize returns the number of bytes of the thrift encoding of the given struct
+func (st *socketTransport) encodedSize(thriftStruct thrift.TStruct) int {
+	st.sizeBuffer.Reset()
+	_ = thriftStruct.Write(st.sizeProtocol)
+	return st.sizeBuffer.Len()
+}
+
+// Append implements the jaeger Transport interface, flushing the buffered spans whenever the
+// next span would not fit within a single datagram
+func (st *socketTransport) Append(span *jaeger.Span) (int, error) {
+	if st.process == nil {
+		st.process = jaeger.BuildJaegerProcessThrift(span)
+		st.processSize = st.encodedSize(st.process)
+	}
+	thriftSpan := jaeger.BuildJaegerThrift(span)
+	spanSize := st.encodedSize(thriftSpan)
+	maxSpanBytes := st.maxPacketSize - socketBatchOverhead - st.processSize
+	if spanSize > maxSpanBytes {
+		return 1, errSpanTooLarge
+	}
+	var flushed int
+	var err error
+	if st.spanBufferSize+spanSize > maxSpanBytes {
+		flushed, err = st.Flush()
+	}
+	st.spans = append(st.spans, thriftSpan)
+	st.spanBufferSize += spanSize
+	return flushed, err
+}
+
+// Flush implements the jaeger Transport interface, sending all buffered spans to the agent
+func (st *socketTransport) Flush() (int, error) {
+	n := len(st.spans)
+	if n == 0 {
+		return 0, nil
+	}
+	batch := &j.Batch{Process: st.process, Spans: st.spans}
+	st.spans = nil
+	st.spanBufferSize = 0
+	st.packetBuffer.Reset()
+	// Sequence IDs are not needed for one-way datagrams
+	st.client.SeqId = 0
+	if err := st.client.EmitBatch(batch); err != nil {
+		return n, err
+	}
+	_, err := st.conn.Write(st.packetBuffer.Bytes())
+	return n, err
+}
+
+// Close implements the jaeger Transport interface
+func (st *socketTransport) Close() error {
+	return st.conn.Close()
+}
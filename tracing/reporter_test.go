@@ -0,0 +1,210 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestAgentHostPort(t *testing.T) {
+	c := Config{AgentPort: 6831}
+	assert.Equal(t, "agent:6831", c.agentHostPort("agent"))
+	assert.Equal(t, "agent:1234", c.agentHostPort("agent:1234"))
+}
+
+func TestNewTracerMultipleAgents(t *testing.T) {
+	first, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer second.Close()
+
+	tracer, err := Config{
+		Enabled:               true,
+		ServiceName:           "test",
+		ReporterMaxQueueSize:  100,
+		ReporterFlushInterval: time.Second,
+		AgentHosts:            []string{first.LocalAddr().String(), second.LocalAddr().String()},
+		DisableGlobalTracer:   true,
+	}.NewTracer()
+	require.NoError(t, err)
+	tracer.Tracer.StartSpan("test", opentracing.Tag{Key: "sampling.priority", Value: uint16(1)}).Finish()
+	// Closing the tracer flushes all pending spans to the agents
+	require.NoError(t, tracer.Closer.Close())
+
+	for _, agent := range []net.PacketConn{first, second} {
+		require.NoError(t, agent.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 65000)
+		n, _, err := agent.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Greater(t, n, 0)
+	}
+}
+
+func TestNewTransportReconnectInterval(t *testing.T) {
+	logger := jaeger.NullLogger
+	transport, err := Config{}.newTransport("127.0.0.1:6831", logger)
+	require.NoError(t, err)
+	defer transport.Close()
+	_, ok := transport.(*reconnectingTransport)
+	assert.False(t, ok)
+
+	transport, err = Config{AgentReconnectInterval: time.Minute}.newTransport("127.0.0.1:6831", logger)
+	require.NoError(t, err)
+	defer transport.Close()
+	reconnecting, ok := transport.(*reconnectingTransport)
+	require.True(t, ok)
+	assert.Equal(t, time.Minute, reconnecting.interval)
+	assert.Equal(t, "127.0.0.1:6831", reconnecting.resolvedAddr)
+}
+
+func TestReconnectingTransportFlush(t *testing.T) {
+	rt, err := newReconnectingTransport("127.0.0.1:6831", time.Minute, 0, jaeger.NullLogger)
+	require.NoError(t, err)
+	defer rt.Close()
+	currentTime := rt.lastResolved
+	rt.now = func() time.Time { return currentTime }
+	rt.resolve = func(string) (string, error) { return "127.0.0.2:6831", nil }
+	originalTransport := rt.transport
+
+	// The address is not re-resolved before the interval elapses
+	_, err = rt.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:6831", rt.resolvedAddr)
+	assert.Equal(t, originalTransport, rt.transport)
+
+	// The transport is replaced once the interval elapses and the address has changed
+	currentTime = currentTime.Add(time.Minute)
+	_, err = rt.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.2:6831", rt.resolvedAddr)
+	assert.NotEqual(t, originalTransport, rt.transport)
+}
+
+func TestNewTracerAgentSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "agent.sock")
+	agent, err := net.ListenPacket("unixgram", socketPath)
+	require.NoError(t, err)
+	defer agent.Close()
+
+	config := Config{
+		Enabled:               true,
+		ServiceName:           "test",
+		ReporterMaxQueueSize:  100,
+		ReporterFlushInterval: time.Second,
+		AgentSocketPath:       socketPath,
+		DisableGlobalTracer:   true,
+	}
+	tracer, err := config.NewTracer()
+	require.NoError(t, err)
+	tracer.Tracer.StartSpan("test", opentracing.Tag{Key: "sampling.priority", Value: uint16(1)}).Finish()
+	// Closing the tracer flushes all pending spans to the agent socket
+	require.NoError(t, tracer.Closer.Close())
+
+	require.NoError(t, agent.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 65000)
+	n, _, err := agent.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Greater(t, n, 0)
+}
+
+func TestConfigValidateAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		expectErr bool
+	}{
+		{"no socket is valid", Config{AgentHost: "agent"}, false},
+		{"socket with default host is valid", Config{AgentSocketPath: "/tmp/agent.sock", AgentHost: "localhost"}, false},
+		{"socket with agent host is invalid", Config{AgentSocketPath: "/tmp/agent.sock", AgentHost: "agent"}, true},
+		{"socket with agent hosts is invalid", Config{AgentSocketPath: "/tmp/agent.sock", AgentHosts: []string{"agent"}}, true},
+		{"packet sizes within range are valid", Config{ReporterMaxPacketSize: 1400}, false},
+		{"packet sizes below the minimum are invalid", Config{ReporterMaxPacketSize: 100}, true},
+		{"packet sizes above the UDP limit are invalid", Config{ReporterMaxPacketSize: 70000}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.config.validateAgent()
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNewTransportMaxPacketSize(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxPacketSize   int
+		expectedPackets int
+	}{
+		{"spans are batched into a single packet by default", 0, 1},
+		{"packets are limited to the max packet size", 1000, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer agent.Close()
+			transport, err := Config{ReporterMaxPacketSize: test.maxPacketSize}.newTransport(agent.LocalAddr().String(), jaeger.NullLogger)
+			require.NoError(t, err)
+			defer transport.Close()
+
+			// Each span is too large to share a packet with another under the max packet size
+			tracer, closer := jaeger.NewTracer(
+				"t", jaeger.NewConstSampler(true), jaeger.NewNullReporter(), jaeger.TracerOptions.MaxTagValueLength(1000),
+			)
+			defer closer.Close()
+			for i := 0; i < 2; i++ {
+				span := tracer.StartSpan("test", opentracing.Tag{Key: "payload", Value: strings.Repeat("x", 600)})
+				_, err = transport.Append(span.(*jaeger.Span))
+				require.NoError(t, err)
+			}
+			_, err = transport.Flush()
+			require.NoError(t, err)
+
+			buf := make([]byte, 65000)
+			for i := 0; i < test.expectedPackets; i++ {
+				require.NoError(t, agent.SetReadDeadline(time.Now().Add(5*time.Second)))
+				n, _, err := agent.ReadFrom(buf)
+				require.NoError(t, err)
+				if test.maxPacketSize > 0 {
+					assert.LessOrEqual(t, n, test.maxPacketSize)
+				}
+			}
+			// No further packets are sent
+			require.NoError(t, agent.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+			_, _, err = agent.ReadFrom(buf)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/spothero/tools/http/writer"
+	"github.com/uber/jaeger-client-go"
+)
+
+// SamplingRule forces the sampling decision for all HTTP requests whose route matches Pattern.
+// Matching requests are sampled with probability Rate, where 1.0 always samples the request and
+// 0.0 never samples the request.
+type SamplingRule struct {
+	Pattern *regexp.Regexp
+	Rate    float64
+}
+
+// NewSamplingRule compiles the given route pattern into a SamplingRule. An error is returned if
+// the pattern is not a valid regular expression or if the rate is not within [0, 1].
+func NewSamplingRule(pattern string, rate float64) (SamplingRule, error) {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return SamplingRule{}, fmt.Errorf("invalid sampling rule pattern %s: %w", pattern, err)
+	}
+	if rate < 0 || rate > 1 {
+		return SamplingRule{}, fmt.Errorf("invalid sampling rule rate %f, must be between 0 and 1", rate)
+	}
+	return SamplingRule{Pattern: compiled, Rate: rate}, nil
+}
+
+// samplingRoute returns the route used to evaluate sampling rules for the request. The route
+// path template is preferred, falling back to the request URL path if no template is found.
+func samplingRoute(r *http.Request) string {
+	if route := writer.FetchRoutePathTemplate(r); route != "" {
+		return route
+	}
+	return r.URL.Path
+}
+
+// HeaderSamplingRule forces the sampling decision for all HTTP requests carrying Header, such as
+// a header marking synthetic monitoring traffic. If Value is set, the header must also have the
+// given value. Matching requests are always sampled if Sample is true and never sampled otherwise.
+type HeaderSamplingRule struct {
+	Header string
+	Value  string
+	Sample bool
+}
+
+// matches returns true if the request carries the header of the rule
+func (hsr HeaderSamplingRule) matches(r *http.Request) bool {
+	values, ok := r.Header[http.CanonicalHeaderKey(hsr.Header)]
+	if !ok {
+		return false
+	}
+	if hsr.Value == "" {
+		return true
+	}
+	for _, value := range values {
+		if value == hsr.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// WithHeaderSampling sets the header sampling rules evaluated on every HTTP request, in order.
+// Header sampling rules take precedence over all other local sampling decisions, but never
+// override the decision of an upstream service. Disabled by default.
+func WithHeaderSampling(rules ...HeaderSamplingRule) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.headerSampling = rules
+	}
+}
+
+// WithLargeRequestSampling forces sampling of all HTTP requests whose declared Content-Length
+// exceeds the given number of bytes, since very large requests are often those which fail.
+// Requests of unknown length, such as chunked requests, are not affected. Large request sampling
+// takes precedence over sampling rules. Disabled by default.
+func WithLargeRequestSampling(threshold int64) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.largeRequestSize = threshold
+	}
+}
+
+// WithSamplingOverrides sets the SamplingOverrides consulted on every HTTP request. Active
+// overrides take precedence over the sampling rules. Disabled by default.
+func WithSamplingOverrides(overrides *SamplingOverrides) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.overrides = overrides
+	}
+}
+
+// SamplingWindow samples all HTTP requests received during a daily time window with probability
+// Rate. Start and End are offsets from midnight, in the location of the middleware clock, such as
+// 22*time.Hour. Windows whose End is before their Start span midnight.
+type SamplingWindow struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  float64
+}
+
+// contains returns true if the given time of day falls within the window
+func (sw SamplingWindow) contains(timeOfDay time.Duration) bool {
+	if sw.Start <= sw.End {
+		return timeOfDay >= sw.Start && timeOfDay < sw.End
+	}
+	return timeOfDay >= sw.Start || timeOfDay < sw.End
+}
+
+// WithSamplingSchedule sets the daily sampling windows evaluated against the middleware clock on
+// every HTTP request, such as a higher rate during nightly batch windows. The first window
+// containing the current time of day applies. Sampling rules take precedence over the schedule.
+// Disabled by default.
+func WithSamplingSchedule(windows ...SamplingWindow) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.samplingSchedule = windows
+	}
+}
+
+// scheduledRate returns the sampling rate of the first sampling window containing the current
+// time of day, if any
+func (o middlewareOptions) scheduledRate() (float64, bool) {
+	if len(o.samplingSchedule) == 0 {
+		return 0, false
+	}
+	now := o.now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	timeOfDay := now.Sub(midnight)
+	for _, window := range o.samplingSchedule {
+		if window.contains(timeOfDay) {
+			return window.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// samplingPriority evaluates the configured sampling rules against the request, in order. If a
+// rule matches, a sampling.priority span tag is returned reflecting the sampling decision.
+// Requests carrying a jaeger debug ID are always sampled by jaeger, so no rule is applied to
+// them. Header sampling rules take precedence over all other rules. Requests larger than the large
+// request threshold are always sampled. Active sampling overrides take precedence over error rate
+// sampling, followed by user sampling and then the sampling rules, and the sampling schedule
+// applies only if no rule matches.
+func (o middlewareOptions) samplingPriority(r *http.Request) (opentracing.Tag, bool) {
+	if hasDebugID(r) {
+		return opentracing.Tag{}, false
+	}
+	for _, rule := range o.headerSampling {
+		if rule.matches(r) {
+			priority := uint16(0)
+			if rule.Sample {
+				priority = 1
+			}
+			return opentracing.Tag{Key: string(ext.SamplingPriority), Value: priority}, true
+		}
+	}
+	if o.largeRequestSize > 0 && r.ContentLength > o.largeRequestSize {
+		return opentracing.Tag{Key: string(ext.SamplingPriority), Value: uint16(1)}, true
+	}
+	route := samplingRoute(r)
+	if o.overrides != nil {
+		if rate, ok := o.overrides.rate(route); ok {
+			return o.samplingPriorityTag(rate), true
+		}
+	}
+	if o.errorRate != nil && o.errorRate.elevated(o.now()) {
+		return o.samplingPriorityTag(o.errorRate.Rate), true
+	}
+	if o.userSampling && o.userCtxKey != nil {
+		if userID, ok := r.Context().Value(o.userCtxKey).(string); ok && userID != "" {
+			priority := uint16(0)
+			if userSamplingFraction(userID) < o.userSamplingRate {
+				priority = 1
+			}
+			return opentracing.Tag{Key: string(ext.SamplingPriority), Value: priority}, true
+		}
+	}
+	for _, rule := range o.samplingRules {
+		if rule.Pattern == nil || !rule.Pattern.MatchString(route) {
+			continue
+		}
+		return o.samplingPriorityTag(rule.Rate), true
+	}
+	if rate, ok := o.scheduledRate(); ok {
+		return o.samplingPriorityTag(rate), true
+	}
+	return opentracing.Tag{}, false
+}
+
+// WithUserSampling samples requests of authenticated users based on a consistent hash of their
+// user ID, so that a given user is either always or never sampled, giving stable traces when
+// debugging specific accounts. A user is sampled if the hash of their ID, as a fraction in [0, 1),
+// is below the given rate. The user ID is read from the request context as configured by
+// WithUserContextKey; requests without a user ID are not affected. Disabled by default.
+func WithUserSampling(rate float64) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.userSampling = true
+		o.userSamplingRate = rate
+	}
+}
+
+// userSamplingFraction returns the consistent hash of the given user ID as a fraction in [0, 1)
+func userSamplingFraction(userID string) float64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(userID))
+	return float64(hash.Sum64()>>11) / (1 << 53)
+}
+
+// samplingPriorityTag returns a sampling.priority span tag sampling the span with the given rate
+func (o middlewareOptions) samplingPriorityTag(rate float64) opentracing.Tag {
+	priority := uint16(0)
+	if o.random() < rate {
+		priority = 1
+	}
+	return opentracing.Tag{Key: string(ext.SamplingPriority), Value: priority}
+}
+
+// samplingHintBaggageKey is the baggage item signaling downstream services to sample their spans
+const samplingHintBaggageKey = "sampling.hint"
+
+// WithSamplingHints propagates forced sampling decisions downstream. When a request is force
+// sampled, by a sampling rule, override, schedule, or large request sampling, the sampling.hint
+// baggage item is set to 1 on its span. Inbound requests carrying the hint are always sampled,
+// even if the upstream service did not sample its span, so that force-sampled traces remain
+// complete across services. Disabled by default.
+func WithSamplingHints(enabled bool) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.samplingHints = enabled
+	}
+}
+
+// hasSamplingHint returns true if the extracted span context carries the sampling hint
+func hasSamplingHint(wireContext opentracing.SpanContext) bool {
+	if wireContext == nil {
+		return false
+	}
+	hinted := false
+	wireContext.ForeachBaggageItem(func(k, v string) bool {
+		if k == samplingHintBaggageKey {
+			hinted = v == "1"
+			return false
+		}
+		return true
+	})
+	return hinted
+}
+
+// hasDebugID returns true if the request carries a jaeger-debug-id header. Jaeger always samples
+// such requests, so local sampling decisions must never unsample them.
+func hasDebugID(r *http.Request) bool {
+	return r.Header.Get(jaeger.JaegerDebugHeader) != ""
+}
+
+// hasUpstreamSamplingDecision returns true if the extracted span context carries a sampling
+// decision made by an upstream service, which must be honored rather than re-decided locally
+func hasUpstreamSamplingDecision(wireContext opentracing.SpanContext) bool {
+	if wireContext == nil {
+		return false
+	}
+	if sc, ok := wireContext.(jaeger.SpanContext); ok {
+		// Contexts carrying only baggage or a debug ID do not continue an upstream trace
+		return sc.IsValid()
+	}
+	return true
+}
@@ -0,0 +1,361 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestNewSamplingRule(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		rate      float64
+		expectErr bool
+	}{
+		{"valid patterns and rates create a rule", "^/admin/.*", 1.0, false},
+		{"invalid patterns result in an error", "^/admin/(", 1.0, true},
+		{"negative rates result in an error", "^/admin/.*", -0.1, true},
+		{"rates above 1 result in an error", "^/admin/.*", 1.1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := NewSamplingRule(test.pattern, test.rate)
+			if test.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.pattern, rule.Pattern.String())
+				assert.Equal(t, test.rate, rule.Rate)
+			}
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareSamplingRules(t *testing.T) {
+	adminRule, err := NewSamplingRule("^/admin/.*", 1.0)
+	require.NoError(t, err)
+	healthRule, err := NewSamplingRule("^/health$", 0.0)
+	require.NoError(t, err)
+	tests := []struct {
+		name          string
+		path          string
+		tracerSampled bool
+		expectSampled bool
+	}{
+		{"matching routes are sampled at the rule rate", "/admin/users", false, true},
+		{"matching routes may be excluded from sampling", "/health", true, false},
+		{"non-matching routes use the tracer sampler when sampled", "/users", true, true},
+		{"non-matching routes use the tracer sampler when not sampled", "/users", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(test.tracerSampled), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+
+			var sampled bool
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+				require.True(t, ok)
+				sampled = spanCtx.IsSampled()
+			})
+			mw := NewHTTPServerMiddleware(WithTracer(tracer), WithSamplingRules(adminRule, healthRule))
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.path, nil))
+			assert.Equal(t, test.expectSampled, sampled)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareSamplingSchedule(t *testing.T) {
+	nightly := SamplingWindow{Start: 22 * time.Hour, End: 4 * time.Hour, Rate: 1.0}
+	daytime := SamplingWindow{Start: 9 * time.Hour, End: 17 * time.Hour, Rate: 0.0}
+	tests := []struct {
+		name          string
+		now           time.Time
+		tracerSampled bool
+		expectSampled bool
+	}{
+		{"requests inside a window spanning midnight are sampled at its rate", time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC), false, true},
+		{"requests after midnight inside a window are sampled at its rate", time.Date(2020, 1, 1, 3, 59, 0, 0, time.UTC), false, true},
+		{"requests inside a window may be excluded from sampling", time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), true, false},
+		{"requests outside all windows use the tracer sampler when sampled", time.Date(2020, 1, 1, 18, 0, 0, 0, time.UTC), true, true},
+		{"requests outside all windows use the tracer sampler when not sampled", time.Date(2020, 1, 1, 4, 0, 0, 0, time.UTC), false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(test.tracerSampled), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+
+			var sampled bool
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+				require.True(t, ok)
+				sampled = spanCtx.IsSampled()
+			})
+			mw := NewHTTPServerMiddleware(
+				WithTracer(tracer),
+				WithSamplingSchedule(nightly, daytime),
+				WithClock(func() time.Time { return test.now }),
+			)
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+			assert.Equal(t, test.expectSampled, sampled)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareSamplingHints(t *testing.T) {
+	forceRule, err := NewSamplingRule("^/checkout$", 1.0)
+	require.NoError(t, err)
+	tests := []struct {
+		name          string
+		opts          []MiddlewareOption
+		expectSampled bool
+		expectHint    string
+	}{
+		{"sampling hints are not propagated by default", nil, false, ""},
+		{"force-sampled traces are sampled downstream", []MiddlewareOption{WithSamplingHints(true)}, true, "1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstreamTracer, upstreamCloser := jaeger.NewTracer("upstream", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+			defer upstreamCloser.Close()
+			downstreamTracer, downstreamCloser := jaeger.NewTracer("downstream", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+			defer downstreamCloser.Close()
+
+			// The downstream hop records its sampling decision and the propagated hint
+			var sampled bool
+			var hint string
+			downstream := NewHTTPServerMiddleware(append(test.opts, WithTracer(downstreamTracer))...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					span := opentracing.SpanFromContext(r.Context())
+					sampled = span.Context().(jaeger.SpanContext).IsSampled()
+					hint = span.BaggageItem("sampling.hint")
+				}),
+			)
+			// The upstream hop force-samples the request and calls the downstream hop, propagating
+			// only baggage, as happens when the trace context is lost by an intermediary
+			upstream := NewHTTPServerMiddleware(append(test.opts, WithTracer(upstreamTracer), WithSamplingRules(forceRule))...)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					req := httptest.NewRequest("GET", "/inventory", nil)
+					opentracing.SpanFromContext(r.Context()).Context().ForeachBaggageItem(func(k, v string) bool {
+						req.Header.Set("uberctx-"+k, v)
+						return true
+					})
+					downstream.ServeHTTP(httptest.NewRecorder(), req)
+				}),
+			)
+			upstream.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/checkout", nil))
+			assert.Equal(t, test.expectSampled, sampled)
+			assert.Equal(t, test.expectHint, hint)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareHeaderSampling(t *testing.T) {
+	keep := HeaderSamplingRule{Header: "X-Synthetic", Value: "canary", Sample: true}
+	drop := HeaderSamplingRule{Header: "X-Synthetic", Sample: false}
+	tests := []struct {
+		name          string
+		rules         []HeaderSamplingRule
+		header        string
+		tracerSampled bool
+		expectSampled bool
+	}{
+		{"matching requests are kept", []HeaderSamplingRule{keep}, "canary", false, true},
+		{"requests with other header values use the tracer sampler", []HeaderSamplingRule{keep}, "monitor", false, false},
+		{"requests with the header are dropped", []HeaderSamplingRule{drop}, "monitor", true, false},
+		{"requests without the header use the tracer sampler", []HeaderSamplingRule{drop}, "", true, true},
+		{"the first matching rule applies", []HeaderSamplingRule{keep, drop}, "canary", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(test.tracerSampled), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+
+			var sampled bool
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+				require.True(t, ok)
+				sampled = spanCtx.IsSampled()
+			})
+			req := httptest.NewRequest("GET", "/users", nil)
+			if test.header != "" {
+				req.Header.Set("X-Synthetic", test.header)
+			}
+			NewHTTPServerMiddleware(WithTracer(tracer), WithHeaderSampling(test.rules...))(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, test.expectSampled, sampled)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareUserSampling(t *testing.T) {
+	type userCtxKeyType int
+	const userCtxKey userCtxKeyType = iota
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	mw := NewHTTPServerMiddleware(WithTracer(tracer), WithUserContextKey(userCtxKey), WithUserSampling(0.5))
+
+	// sampled returns the sampling decision of a request made by the given user
+	sampled := func(userID string) bool {
+		var isSampled bool
+		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			isSampled = opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext).IsSampled()
+		})
+		req := httptest.NewRequest("GET", "/users", nil)
+		if userID != "" {
+			req = req.WithContext(context.WithValue(req.Context(), userCtxKey, userID))
+		}
+		mw(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+		return isSampled
+	}
+
+	// Every user is consistently sampled or not across requests
+	decisions := make(map[bool]int)
+	for i := 0; i < 20; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		expected := userSamplingFraction(userID) < 0.5
+		for j := 0; j < 3; j++ {
+			assert.Equal(t, expected, sampled(userID))
+		}
+		decisions[expected]++
+	}
+	// Both decisions are made across users at a rate of one half
+	assert.Greater(t, decisions[true], 0)
+	assert.Greater(t, decisions[false], 0)
+	// Requests without a user use the tracer sampler
+	assert.False(t, sampled(""))
+}
+
+func TestHTTPServerMiddlewareLargeRequestSampling(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []MiddlewareOption
+		contentLength int64
+		expectSampled bool
+	}{
+		{"large requests use the tracer sampler by default", nil, 1 << 20, false},
+		{"requests over the threshold are sampled", []MiddlewareOption{WithLargeRequestSampling(1024)}, 1 << 20, true},
+		{"requests under the threshold use the tracer sampler", []MiddlewareOption{WithLargeRequestSampling(1024)}, 512, false},
+		{"requests of unknown length use the tracer sampler", []MiddlewareOption{WithLargeRequestSampling(1024)}, -1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+			defer closer.Close()
+
+			var sampled bool
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+				require.True(t, ok)
+				sampled = spanCtx.IsSampled()
+			})
+			req := httptest.NewRequest("POST", "/uploads", nil)
+			req.ContentLength = test.contentLength
+			NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, test.expectSampled, sampled)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareUpstreamSamplingDecision(t *testing.T) {
+	healthRule, err := NewSamplingRule("^/health$", 0.0)
+	require.NoError(t, err)
+	adminRule, err := NewSamplingRule("^/admin$", 1.0)
+	require.NoError(t, err)
+	tests := []struct {
+		name            string
+		path            string
+		upstreamSampled bool
+		expectSampled   bool
+	}{
+		{"sampled upstream contexts are sampled under a 0% sampler", "/users", true, true},
+		{"sampled upstream contexts are not overridden by sampling rules", "/health", true, true},
+		{"unsampled upstream contexts are not overridden by sampling rules", "/admin", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstreamTracer, upstreamCloser := jaeger.NewTracer("upstream", jaeger.NewConstSampler(test.upstreamSampled), jaeger.NewInMemoryReporter())
+			defer upstreamCloser.Close()
+			sampler, err := jaeger.NewProbabilisticSampler(0)
+			require.NoError(t, err)
+			tracer, closer := jaeger.NewTracer("t", sampler, jaeger.NewInMemoryReporter())
+			defer closer.Close()
+
+			upstreamSpan := upstreamTracer.StartSpan("upstream")
+			defer upstreamSpan.Finish()
+			req := httptest.NewRequest("GET", test.path, nil)
+			require.NoError(t, upstreamTracer.Inject(upstreamSpan.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header)))
+
+			var sampled bool
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx, ok := opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+				require.True(t, ok)
+				sampled = spanCtx.IsSampled()
+			})
+			mw := NewHTTPServerMiddleware(WithTracer(tracer), WithSamplingRules(healthRule, adminRule))
+			mw(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, test.expectSampled, sampled)
+		})
+	}
+}
+
+func TestHTTPServerMiddlewareDebugIDSampling(t *testing.T) {
+	type userCtxKeyType int
+	const userCtxKey userCtxKeyType = iota
+	neverRule, err := NewSamplingRule(".*", 0.0)
+	require.NoError(t, err)
+	overrides := NewSamplingOverrides()
+	overrides.Set(neverRule, time.Hour)
+	tests := []struct {
+		name string
+		opts []MiddlewareOption
+	}{
+		{"header sampling rules do not unsample debug requests", []MiddlewareOption{WithHeaderSampling(HeaderSamplingRule{Header: jaeger.JaegerDebugHeader, Sample: false})}},
+		{"sampling rules do not unsample debug requests", []MiddlewareOption{WithSamplingRules(neverRule)}},
+		{"sampling overrides do not unsample debug requests", []MiddlewareOption{WithSamplingOverrides(overrides)}},
+		{"sampling schedules do not unsample debug requests", []MiddlewareOption{WithSamplingSchedule(SamplingWindow{Start: 0, End: 24 * time.Hour, Rate: 0.0})}},
+		{"user sampling does not unsample debug requests", []MiddlewareOption{WithUserContextKey(userCtxKey), WithUserSampling(0.0)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reporter := jaeger.NewInMemoryReporter()
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), reporter)
+			defer closer.Close()
+
+			var spanCtx jaeger.SpanContext
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				spanCtx = opentracing.SpanFromContext(r.Context()).Context().(jaeger.SpanContext)
+			})
+			req := httptest.NewRequest("GET", "/users", nil)
+			req.Header.Set(jaeger.JaegerDebugHeader, "support-ticket-123")
+			req = req.WithContext(context.WithValue(req.Context(), userCtxKey, "12345"))
+			NewHTTPServerMiddleware(append(test.opts, WithTracer(tracer))...)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.True(t, spanCtx.IsSampled())
+			assert.True(t, spanCtx.IsDebug())
+			assert.Equal(t, 1, reporter.SpansSubmitted())
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/uber/jaeger-client-go"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+// BufferedSpan is the summary of a finished span retained by a SpanBuffer
+type BufferedSpan struct {
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentID      string                 `json:"parent_id,omitempty"`
+	OperationName string                 `json:"operation_name"`
+	StartTime     time.Time              `json:"start_time"`
+	Duration      time.Duration          `json:"duration"`
+	Tags          map[string]interface{} `json:"tags,omitempty"`
+}
+
+// SpanBuffer is a jaeger Reporter which retains the most recently finished sampled spans in
+// memory, so that recent spans may be inspected for post-mortem debugging without a collector.
+// Memory is bounded by the size of the buffer; once full, the oldest span is discarded for every
+// new span. Set the SpanBuffer on the Config to retain the spans of the configured tracer.
+type SpanBuffer struct {
+	mutex sync.Mutex
+	spans []BufferedSpan
+	next  int
+	full  bool
+}
+
+// NewSpanBuffer creates a SpanBuffer retaining up to size spans. Sizes less than 1 retain 1 span.
+func NewSpanBuffer(size int) *SpanBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &SpanBuffer{spans: make([]BufferedSpan, size)}
+}
+
+// newBufferedSpan summarizes the given span
+func newBufferedSpan(span *jaeger.Span) BufferedSpan {
+	sc := span.Context().(jaeger.SpanContext)
+	thriftSpan := jaeger.BuildJaegerThrift(span)
+	bufferedSpan := BufferedSpan{
+		TraceID:       sc.TraceID().String(),
+		SpanID:        sc.SpanID().String(),
+		OperationName: thriftSpan.OperationName,
+		StartTime:     time.Unix(0, thriftSpan.StartTime*int64(time.Microsecond)),
+		Duration:      time.Duration(thriftSpan.Duration) * time.Microsecond,
+	}
+	if sc.ParentID() != 0 {
+		bufferedSpan.ParentID = sc.ParentID().String()
+	}
+	if len(thriftSpan.Tags) > 0 {
+		bufferedSpan.Tags = make(map[string]interface{}, len(thriftSpan.Tags))
+		for _, tag := range thriftSpan.Tags {
+			bufferedSpan.Tags[tag.Key] = thriftTagValue(tag)
+		}
+	}
+	return bufferedSpan
+}
+
+// thriftTagValue returns the value of the given thrift span tag
+func thriftTagValue(tag *j.Tag) interface{} {
+	switch tag.VType {
+	case j.TagType_BOOL:
+		return tag.GetVBool()
+	case j.TagType_LONG:
+		return tag.GetVLong()
+	case j.TagType_DOUBLE:
+		return tag.GetVDouble()
+	case j.TagType_BINARY:
+		return tag.GetVBinary()
+	}
+	return tag.GetVStr()
+}
+
+// Report implements jaeger.Reporter, retaining the finished span and discarding the oldest span
+// if the buffer is full
+func (sb *SpanBuffer) Report(span *jaeger.Span) {
+	bufferedSpan := newBufferedSpan(span)
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	sb.spans[sb.next] = bufferedSpan
+	sb.next = (sb.next + 1) % len(sb.spans)
+	if sb.next == 0 {
+		sb.full = true
+	}
+}
+
+// Close implements jaeger.Reporter. Retained spans remain available after the tracer is closed.
+func (sb *SpanBuffer) Close() {}
+
+// Spans returns the retained spans, from oldest to most recently finished
+func (sb *SpanBuffer) Spans() []BufferedSpan {
+	sb.mutex.Lock()
+	defer sb.mutex.Unlock()
+	if !sb.full {
+		return append([]BufferedSpan{}, sb.spans[:sb.next]...)
+	}
+	return append(append([]BufferedSpan{}, sb.spans[sb.next:]...), sb.spans[:sb.next]...)
+}
+
+// DumpHandler returns an HTTP handler which responds with the retained spans as JSON, from oldest
+// to most recently finished. The handler is never registered automatically and should only be
+// mounted on an internal or otherwise protected route, since spans may contain sensitive data.
+func (sb *SpanBuffer) DumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(sb.Spans())
+	})
+}
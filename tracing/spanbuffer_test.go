@@ -0,0 +1,94 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jaeger "github.com/uber/jaeger-client-go"
+)
+
+func TestSpanBuffer(t *testing.T) {
+	buffer := NewSpanBuffer(2)
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), buffer)
+	defer closer.Close()
+	assert.Empty(t, buffer.Spans())
+
+	tracer.StartSpan("first").Finish()
+	tracer.StartSpan("second").Finish()
+	tracer.StartSpan("third").Finish()
+
+	// Only the most recently finished spans are retained, from oldest to newest
+	spans := buffer.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "second", spans[0].OperationName)
+	assert.Equal(t, "third", spans[1].OperationName)
+
+	recorder := httptest.NewRecorder()
+	buffer.DumpHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/spans", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var dumped []BufferedSpan
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&dumped))
+	require.Len(t, dumped, 2)
+	assert.Equal(t, spans[1].SpanID, dumped[1].SpanID)
+
+	recorder = httptest.NewRecorder()
+	buffer.DumpHandler().ServeHTTP(recorder, httptest.NewRequest("DELETE", "/debug/spans", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestNewBufferedSpan(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewNullReporter())
+	defer closer.Close()
+	parent := tracer.StartSpan("parent")
+	child := tracer.StartSpan("child", opentracing.ChildOf(parent.Context()))
+	child.SetTag("error", true)
+	child.SetTag("db.args_count", 2)
+	child.Finish()
+
+	bufferedSpan := newBufferedSpan(child.(*jaeger.Span))
+	parentCtx := parent.Context().(jaeger.SpanContext)
+	assert.Equal(t, "child", bufferedSpan.OperationName)
+	assert.Equal(t, parentCtx.TraceID().String(), bufferedSpan.TraceID)
+	assert.Equal(t, parentCtx.SpanID().String(), bufferedSpan.ParentID)
+	assert.Equal(t, true, bufferedSpan.Tags["error"])
+	assert.Equal(t, int64(2), bufferedSpan.Tags["db.args_count"])
+}
+
+func TestNewTracerSpanBuffer(t *testing.T) {
+	buffer := NewSpanBuffer(10)
+	tracer, err := Config{
+		Enabled:             true,
+		ServiceName:         "test",
+		SamplerParam:        1,
+		AgentHost:           "localhost",
+		AgentPort:           6831,
+		DisableGlobalTracer: true,
+		SpanBuffer:          buffer,
+	}.NewTracer()
+	require.NoError(t, err)
+	defer tracer.Closer.Close()
+
+	tracer.Tracer.StartSpan("test").Finish()
+	spans := buffer.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "test", spans[0].OperationName)
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// spanStackCtxKeyType is the type used to uniquely place the span stack in contexts
+type spanStackCtxKeyType int
+
+// spanStackCtxKey is the key into any context.Context which maps to the top of the span stack
+const spanStackCtxKey spanStackCtxKeyType = iota
+
+// spanStackFrame is an entry of the span stack, holding a pushed span and the context from which
+// it was pushed
+type spanStackFrame struct {
+	span   opentracing.Span
+	parent context.Context
+}
+
+// PushSpan starts a span with StartSpanFromContext as a child of the span on the given context and
+// pushes it onto the span stack of the returned context. The span is finished by calling PopSpan
+// with the returned context, or any context derived from it. This is a convenience for procedural
+// code managing deeply nested spans, where restructuring to thread each span's context is hard:
+//
+//	ctx = tracing.PushSpan(ctx, "load")
+//	ctx = tracing.PushSpan(ctx, "parse")
+//	ctx = tracing.PopSpan(ctx) // finishes parse, ctx is back to the load span
+//	ctx = tracing.PopSpan(ctx) // finishes load
+func PushSpan(ctx context.Context, operationName string) context.Context {
+	span, spanCtx := StartSpanFromContext(ctx, operationName)
+	return context.WithValue(spanCtx, spanStackCtxKey, spanStackFrame{span: span, parent: ctx})
+}
+
+// PopSpan finishes the span most recently pushed onto the span stack of the given context with
+// PushSpan and returns the context from which it was pushed. If the span stack is empty, the given
+// context is returned unmodified.
+func PopSpan(ctx context.Context) context.Context {
+	frame, ok := ctx.Value(spanStackCtxKey).(spanStackFrame)
+	if !ok {
+		return ctx
+	}
+	frame.span.Finish()
+	return frame.parent
+}
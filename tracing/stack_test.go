@@ -0,0 +1,57 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushPopSpan(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	root, rootCtx := opentracing.StartSpanFromContext(context.Background(), "root")
+	ctx := PushSpan(rootCtx, "outer")
+	outer := opentracing.SpanFromContext(ctx)
+	ctx = PushSpan(ctx, "inner")
+	inner := opentracing.SpanFromContext(ctx)
+
+	// Popping finishes the top span and restores the span pushed before it
+	ctx = PopSpan(ctx)
+	assert.Equal(t, outer, opentracing.SpanFromContext(ctx))
+	ctx = PopSpan(ctx)
+	assert.Equal(t, root, opentracing.SpanFromContext(ctx))
+	assert.Equal(t, rootCtx, ctx)
+
+	// Popping an empty stack is a no-op
+	assert.Equal(t, rootCtx, PopSpan(ctx))
+	root.Finish()
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "inner", spans[0].OperationName)
+	assert.Equal(t, "outer", spans[1].OperationName)
+	assert.Equal(t, "root", spans[2].OperationName)
+	assert.Equal(t, inner.(*mocktracer.MockSpan).SpanContext.SpanID, spans[0].SpanContext.SpanID)
+	assert.Equal(t, spans[1].SpanContext.SpanID, spans[0].ParentID)
+	assert.Equal(t, spans[2].SpanContext.SpanID, spans[1].ParentID)
+}
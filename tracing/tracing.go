@@ -19,10 +19,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/spothero/tools/log"
+	sql "github.com/spothero/tools/sql/middleware"
 	"github.com/uber/jaeger-client-go"
 	jaegercfg "github.com/uber/jaeger-client-go/config"
 	jaegerzap "github.com/uber/jaeger-client-go/log/zap"
@@ -38,6 +42,24 @@ type CorrelationIDCtxKeyType int
 // SpotHero tracing and logging.
 const CorrelationIDCtxKey CorrelationIDCtxKeyType = iota
 
+// suppressCtxKeyType is the type used to uniquely place the tracing suppression flag in contexts
+type suppressCtxKeyType int
+
+// suppressCtxKey is the key into any context.Context which marks tracing as suppressed
+const suppressCtxKey suppressCtxKeyType = iota
+
+// verboseCtxKeyType is the type used to uniquely place the span verbosity flag in contexts
+type verboseCtxKeyType int
+
+// verboseCtxKey is the key into any context.Context which marks spans as verbose
+const verboseCtxKey verboseCtxKeyType = iota
+
+// tracerCtxKeyType is the type used to uniquely place injected tracers in contexts
+type tracerCtxKeyType int
+
+// tracerCtxKey is the key into any context.Context which maps to an injected tracer
+const tracerCtxKey tracerCtxKeyType = iota
+
 // Config defines the necessary configuration for instantiating a Tracer
 type Config struct {
 	Enabled               bool
@@ -46,13 +68,88 @@ type Config struct {
 	ReporterLogSpans      bool
 	ReporterMaxQueueSize  int
 	ReporterFlushInterval time.Duration
+	// ReporterMaxPacketSize, if set, is the maximum size in bytes of the UDP packets sent to the
+	// agent, between 512 and 65000. Lower it below the network MTU to avoid fragmentation, which
+	// causes spans to be dropped. Defaults to 65000.
+	ReporterMaxPacketSize int
 	AgentHost             string
 	AgentPort             int
-	ServiceName           string
+	// AgentHosts optionally lists multiple agents, as "host" or "host:port", to which spans are
+	// reported. When set, AgentHost is ignored and every span is reported to every agent so that
+	// traces are still captured if an agent is unavailable. Note that this duplicates reporting
+	// traffic, and that agents which share a collector will store duplicate spans.
+	AgentHosts []string
+	// AgentReconnectInterval, if set, is the interval at which agent addresses are re-resolved so
+	// that spans continue to be delivered after an agent changes address. Disabled by default.
+	AgentReconnectInterval time.Duration
+	// AgentSocketPath, if set, is the path of the Unix datagram socket on which the agent listens.
+	// Spans are reported to the socket instead of over UDP, so AgentHosts must not be set and
+	// AgentHost must be left unset or at its default of localhost.
+	AgentSocketPath     string
+	ServiceName         string
+	DisableGlobalTracer bool       // If true, the configured tracer is not registered as the OpenTracing global tracer
+	Propagator          Propagator // Optional custom propagation for the HTTPHeaders format. Defaults to the jaeger headers.
+	Version             string     // Optional application version, tagged as version on the tracer process and on the spans of the Tracer's middleware
+	BuildInfoTags       bool       // If true, runtime.version, build.version, and build.revision are tagged on the tracer process
+	BuildRevision       string     // VCS revision of the build, such as a Git SHA set with -ldflags, tagged as build.revision
+	// Baggage restrictions limit the baggage keys and value sizes which may be set on spans, as
+	// configured centrally on the agent's baggage restrictions endpoint
+	BaggageRestrictionsEnabled         bool
+	BaggageRestrictionsHostPort        string        // Defaults to localhost:5778 if not set
+	BaggageRestrictionsRefreshInterval time.Duration // Defaults to one minute if not set
+	BaggageRestrictionsDenyOnFailure   bool          // If true, no baggage may be set until restrictions are retrieved
+	// Exporter selects how spans are exported, either ExporterJaeger (the default) or ExporterOTLP.
+	// When exporting with OTLP, spans are sent to OTLPEndpoint, the full collector URL such as
+	// http://collector:4318/v1/traces, using OTLPProtocol. OTLPProtocol defaults to
+	// OTLPProtocolHTTP, the only supported protocol.
+	Exporter     string
+	OTLPProtocol string
+	OTLPEndpoint string
+	// SpanBuffer, if set, additionally retains the most recently finished spans in memory for
+	// on-demand inspection, see NewSpanBuffer
+	SpanBuffer *SpanBuffer
 }
 
-// ConfigureTracer instantiates and configures the OpenTracer and returns the tracer closer
-func (c Config) ConfigureTracer() io.Closer {
+// Tracer bundles a configured OpenTracing Tracer with its Closer so that it may be provided
+// to dependency injection frameworks instead of relying on the OpenTracing global tracer.
+type Tracer struct {
+	Tracer  opentracing.Tracer
+	Closer  io.Closer
+	Version string // The application version tagged on the spans of the Tracer's middleware, if any
+}
+
+// NewHTTPServerMiddleware returns the tracing HTTP server middleware configured to use this
+// Tracer and to tag its version. Any additional options are applied after the Tracer options.
+func (t Tracer) NewHTTPServerMiddleware(opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	return NewHTTPServerMiddleware(append([]MiddlewareOption{WithTracer(t.Tracer), WithVersion(t.Version)}, opts...)...)
+}
+
+// NewSQLMiddleware returns the tracing SQL middleware configured to use this Tracer and to tag
+// its version. Any additional options are applied after the Tracer options.
+func (t Tracer) NewSQLMiddleware(opts ...SQLMiddlewareOption) sql.MiddlewareStart {
+	return NewSQLMiddleware(append([]SQLMiddlewareOption{WithSQLTracer(t.Tracer), WithSQLVersion(t.Version)}, opts...)...)
+}
+
+// NewHTTPClient returns a copy of the given HTTP client whose transport is traced with this
+// Tracer. See NewHTTPClient for details.
+func (t Tracer) NewHTTPClient(base *http.Client) *http.Client {
+	return newHTTPClient(base, t.Tracer)
+}
+
+// NewProxyTransport returns a RoundTripper tracing proxied requests with this Tracer. See
+// NewProxyTransport for details.
+func (t Tracer) NewProxyTransport(base http.RoundTripper) http.RoundTripper {
+	return newProxyTransport(base, t.Tracer)
+}
+
+// ContextWithTracer returns a context on which spans without a parent span are started with this
+// Tracer. See ContextWithTracer for details.
+func (t Tracer) ContextWithTracer(ctx context.Context) context.Context {
+	return ContextWithTracer(ctx, t.Tracer)
+}
+
+// jaegerConfiguration returns the jaeger configuration for the Config
+func (c Config) jaegerConfiguration() jaegercfg.Configuration {
 	samplerConfig := jaegercfg.SamplerConfig{}
 	if c.SamplerType == "" {
 		c.SamplerType = jaeger.SamplerTypeConst
@@ -72,25 +169,138 @@ func (c Config) ConfigureTracer() io.Closer {
 		Reporter:    &reporterConfig,
 		Disabled:    !c.Enabled,
 	}
+	if c.BuildInfoTags {
+		jaegerConfig.Tags = buildInfoTags(c.BuildRevision)
+	}
+	if c.Version != "" {
+		jaegerConfig.Tags = append(jaegerConfig.Tags, opentracing.Tag{Key: "version", Value: c.Version})
+	}
+	if c.BaggageRestrictionsEnabled {
+		jaegerConfig.BaggageRestrictions = &jaegercfg.BaggageRestrictionsConfig{
+			DenyBaggageOnInitializationFailure: c.BaggageRestrictionsDenyOnFailure,
+			HostPort:                           c.BaggageRestrictionsHostPort,
+			RefreshInterval:                    c.BaggageRestrictionsRefreshInterval,
+		}
+	}
+	return jaegerConfig
+}
 
+// buildInfoTags returns the runtime.version tag, set to the version of the Go toolchain which built
+// the binary, the build.version tag, set to the version of the main module stamped into the
+// binary, and the build.revision tag, set to the given VCS revision. The build.version tag is
+// omitted if the build information could not be read or if the binary was built from a local
+// checkout, whose version is reported as (devel). The build.revision tag is omitted if the
+// revision is empty.
+func buildInfoTags(revision string) []opentracing.Tag {
+	tags := []opentracing.Tag{{Key: "runtime.version", Value: runtime.Version()}}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		tags = append(tags, opentracing.Tag{Key: "build.version", Value: info.Main.Version})
+	}
+	if revision != "" {
+		tags = append(tags, opentracing.Tag{Key: "build.revision", Value: revision})
+	}
+	return tags
+}
+
+// NewTracer instantiates and configures the OpenTracer and returns it bundled with the tracer
+// closer. Unless DisableGlobalTracer is set, the tracer is also registered as the OpenTracing
+// global tracer.
+func (c Config) NewTracer() (Tracer, error) {
+	if err := c.validateAgent(); err != nil {
+		return Tracer{}, fmt.Errorf("invalid jaeger agent configuration: %w", err)
+	}
+	if err := c.validateExporter(); err != nil {
+		return Tracer{}, err
+	}
+	jaegerConfig := c.jaegerConfiguration()
 	logger := log.Get(context.Background()).Named("jaeger")
-	tracer, closer, err := jaegerConfig.NewTracer(
-		jaegercfg.Logger(jaegerzap.NewLogger(logger)))
+	jaegerLogger := jaegerzap.NewLogger(logger)
+	options := []jaegercfg.Option{jaegercfg.Logger(jaegerLogger)}
+	if c.Propagator != nil {
+		options = append(
+			options,
+			jaegercfg.Injector(opentracing.HTTPHeaders, c.Propagator),
+			jaegercfg.Extractor(opentracing.HTTPHeaders, c.Propagator),
+		)
+	}
+	if c.Enabled && c.usesCustomReporter() {
+		reporter, err := c.newReporter(jaegerLogger)
+		if err != nil {
+			return Tracer{}, fmt.Errorf("could not initialize jaeger reporter: %w", err)
+		}
+		options = append(options, jaegercfg.Reporter(reporter))
+	}
+	tracer, closer, err := jaegerConfig.NewTracer(options...)
 	if err != nil {
-		logger.Error("could not initialize jaeger tracer", zap.Error(err))
-		return nil
+		return Tracer{}, fmt.Errorf("could not initialize jaeger tracer: %w", err)
 	}
 	logger.Info("jaeger tracer configured", zap.Bool("enabled", c.Enabled))
-	opentracing.SetGlobalTracer(tracer)
-	return closer
+	if !c.DisableGlobalTracer {
+		opentracing.SetGlobalTracer(tracer)
+	}
+	return Tracer{Tracer: tracer, Closer: closer, Version: c.Version}, nil
+}
+
+// ConfigureTracer instantiates and configures the OpenTracer and returns the tracer closer
+func (c Config) ConfigureTracer() io.Closer {
+	tracer, err := c.NewTracer()
+	if err != nil {
+		log.Get(context.Background()).Named("jaeger").Error("could not initialize jaeger tracer", zap.Error(err))
+		return nil
+	}
+	return tracer.Closer
+}
+
+// HeaderCasing controls the casing of trace headers injected into outbound requests
+type HeaderCasing int
+
+const (
+	// HeaderCasingCanonical injects headers in their canonical MIME form, such as Uber-Trace-Id
+	HeaderCasingCanonical HeaderCasing = iota
+	// HeaderCasingLowercase injects headers in lowercase, such as uber-trace-id, for intermediaries
+	// which reject or rewrite mixed-case headers
+	HeaderCasingLowercase
+)
+
+// outboundOptions contains the configuration for injecting trace headers into outbound requests
+type outboundOptions struct {
+	headerCasing HeaderCasing
 }
 
-// TraceOutbound injects outbound HTTP requests with OpenTracing headers
-func TraceOutbound(r *http.Request, span opentracing.Span) error {
-	return opentracing.GlobalTracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(r.Header))
+// OutboundOption is a function that adds configuration to TraceOutbound
+type OutboundOption func(*outboundOptions)
+
+// WithHeaderCasing sets the casing of the trace headers injected by TraceOutbound. Defaults to
+// HeaderCasingCanonical.
+func WithHeaderCasing(casing HeaderCasing) OutboundOption {
+	return func(o *outboundOptions) {
+		o.headerCasing = casing
+	}
+}
+
+// TraceOutbound injects outbound HTTP requests with OpenTracing headers, using the tracer which
+// started the given span
+func TraceOutbound(r *http.Request, span opentracing.Span, opts ...OutboundOption) error {
+	options := outboundOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.headerCasing == HeaderCasingCanonical {
+		return span.Tracer().Inject(
+			span.Context(),
+			opentracing.HTTPHeaders,
+			opentracing.HTTPHeadersCarrier(r.Header))
+	}
+	// Header.Set always canonicalizes keys, so lowercase headers are set on the map directly
+	carrier := opentracing.TextMapCarrier{}
+	if err := span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, carrier); err != nil {
+		return err
+	}
+	for key, value := range carrier {
+		r.Header.Del(key)
+		r.Header[strings.ToLower(key)] = []string{value}
+	}
+	return nil
 }
 
 // EmbedCorrelationID embeds the current Trace ID as the correlation ID in the context logger
@@ -109,3 +319,197 @@ func EmbedCorrelationID(ctx context.Context) context.Context {
 	}
 	return ctx
 }
+
+// IsSampled returns true if the active span on the given context has been sampled. This is
+// useful for skipping expensive debug work for spans which will never be reported. False is
+// returned if no span is present on the context or if the span was not created by a Jaeger tracer.
+func IsSampled(ctx context.Context) bool {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if sc, ok := span.Context().(jaeger.SpanContext); ok {
+			return sc.IsSampled()
+		}
+	}
+	return false
+}
+
+// TracedLogger returns the context logger enriched with the trace_id and span_id of the active
+// span on the given context. Unlike EmbedCorrelationID, the IDs are read directly from the span
+// context, so logs are correlated with traces even in code paths which did not pass through the
+// tracing middleware. If no Jaeger span is present, the context logger is returned unmodified.
+func TracedLogger(ctx context.Context) *zap.Logger {
+	logger := log.Get(ctx)
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if sc, ok := span.Context().(jaeger.SpanContext); ok {
+			logger = logger.With(
+				zap.String("trace_id", sc.TraceID().String()),
+				zap.String("span_id", sc.SpanID().String()),
+			)
+		}
+	}
+	return logger
+}
+
+// SpanLogFields returns the trace_id, span_id, and sampled fields of the active span on the given
+// context for manual enrichment of log lines, such as log.Get(ctx).Info("msg", fields...). If no
+// Jaeger span is present, no fields are returned.
+func SpanLogFields(ctx context.Context) []zap.Field {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		if sc, ok := span.Context().(jaeger.SpanContext); ok {
+			return []zap.Field{
+				zap.String("trace_id", sc.TraceID().String()),
+				zap.String("span_id", sc.SpanID().String()),
+				zap.Bool("sampled", sc.IsSampled()),
+			}
+		}
+	}
+	return []zap.Field{}
+}
+
+// StartSpanLogged starts a span with StartSpanFromContext and returns the span along with a
+// context containing it and a logger enriched with the trace_id and span_id of the new span, as
+// with TracedLogger, as well as the given fields. This keeps logs written during a unit of work
+// aligned with the span measuring it.
+func StartSpanLogged(ctx context.Context, operationName string, fields ...zap.Field) (opentracing.Span, context.Context) {
+	span, spanCtx := StartSpanFromContext(ctx, operationName)
+	return span, log.NewContext(spanCtx, TracedLogger(spanCtx).With(fields...))
+}
+
+// Suppress returns a context on which tracing is suppressed. StartSpanFromContext and the
+// middleware in this package create no-op spans for the returned context and all contexts derived
+// from it. This is useful for carving extremely hot code paths out of traces without disabling
+// the tracer.
+func Suppress(ctx context.Context) context.Context {
+	return context.WithValue(ctx, suppressCtxKey, true)
+}
+
+// IsSuppressed returns true if tracing has been suppressed on the given context
+func IsSuppressed(ctx context.Context) bool {
+	suppressed, _ := ctx.Value(suppressCtxKey).(bool)
+	return suppressed
+}
+
+// WithSpanVerbose returns a context on which spans are verbose. The SQL middleware and GoWithSpan
+// emit additional debug span logs for the returned context and all contexts derived from it. This
+// allows deep instrumentation of specific requests, such as flagged debugging requests, without
+// the cost of verbose logging on every request.
+func WithSpanVerbose(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verboseCtxKey, true)
+}
+
+// IsSpanVerbose returns true if spans have been marked as verbose on the given context
+func IsSpanVerbose(ctx context.Context) bool {
+	verbose, _ := ctx.Value(verboseCtxKey).(bool)
+	return verbose
+}
+
+// tracerForContext returns the given tracer, or a no-op tracer if tracing is suppressed on the
+// given context
+func tracerForContext(ctx context.Context, tracer opentracing.Tracer) opentracing.Tracer {
+	if IsSuppressed(ctx) {
+		return opentracing.NoopTracer{}
+	}
+	return tracer
+}
+
+// ContextWithTracer returns a context carrying the given tracer. Spans started from the returned
+// context, or contexts derived from it, without a parent span, such as by StartSpanFromContext,
+// StartJobTrace, and WorkerSpan, use the tracer rather than the OpenTracing global tracer. This
+// allows a tracer provided by dependency injection, with DisableGlobalTracer set, to be used
+// throughout the package.
+func ContextWithTracer(ctx context.Context, tracer opentracing.Tracer) context.Context {
+	return context.WithValue(ctx, tracerCtxKey, tracer)
+}
+
+// contextTracer returns the tracer which started the span on the given context, if any,
+// otherwise the tracer set with ContextWithTracer, falling back to the OpenTracing global tracer
+func contextTracer(ctx context.Context) opentracing.Tracer {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		return span.Tracer()
+	}
+	if tracer, ok := ctx.Value(tracerCtxKey).(opentracing.Tracer); ok {
+		return tracer
+	}
+	return opentracing.GlobalTracer()
+}
+
+// StartSpanFromContext starts a span as a child of the span on the given context, if any, and
+// returns the span along with a context containing it. The span is started with the tracer of
+// its parent span, otherwise the tracer set with ContextWithTracer, or the OpenTracing global
+// tracer. If the context carries no span but was returned by DeserializeContext, the span
+// follows from the deserialized span context. If tracing is suppressed on the given context, a
+// no-op span is returned.
+func StartSpanFromContext(ctx context.Context, operationName string, opts ...opentracing.StartSpanOption) (opentracing.Span, context.Context) {
+	if opentracing.SpanFromContext(ctx) == nil {
+		if sc, ok := ctx.Value(remoteSpanCtxKey).(opentracing.SpanContext); ok {
+			opts = append(opts, opentracing.FollowsFrom(sc))
+		}
+	}
+	return opentracing.StartSpanFromContextWithTracer(ctx, tracerForContext(ctx, contextTracer(ctx)), operationName, opts...)
+}
+
+// StartRenderSpan starts a span measuring the rendering of the given template. The returned span
+// must be finished once rendering completes. Span names are in the format
+// "template_<templateName>" and all render spans are tagged with the following tags:
+// * component - Always set to "template"
+// * template.name - Always set to the template name
+func StartRenderSpan(ctx context.Context, templateName string) (opentracing.Span, context.Context) {
+	span, spanCtx := StartSpanFromContext(ctx, fmt.Sprintf("template_%s", templateName))
+	span = span.
+		SetTag("component", "template").
+		SetTag("template.name", templateName)
+	return span, spanCtx
+}
+
+// StartSpanIfSampled starts a span as a child of the span on the given context only if the parent
+// span is sampled. If the parent span is not sampled, or tracing is suppressed on the context, a
+// no-op span, the unmodified context, and false are returned so that callers may skip computing
+// expensive tags. Otherwise, the started span, a context containing it, and the sampling decision
+// of the started span are returned. Spans from tracers other than Jaeger are considered sampled.
+func StartSpanIfSampled(ctx context.Context, operationName string, opts ...opentracing.StartSpanOption) (opentracing.Span, context.Context, bool) {
+	if IsSuppressed(ctx) {
+		return opentracing.NoopTracer{}.StartSpan(operationName), ctx, false
+	}
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		if sc, ok := parent.Context().(jaeger.SpanContext); ok && !sc.IsSampled() {
+			return opentracing.NoopTracer{}.StartSpan(operationName), ctx, false
+		}
+	}
+	span, spanCtx := StartSpanFromContext(ctx, operationName, opts...)
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return span, spanCtx, sc.IsSampled()
+	}
+	return span, spanCtx, true
+}
+
+// MarkDegraded flags the span on the given context as having served degraded results, such as
+// stale or partial data from a fallback path, without marking it as errored. The span is tagged
+// with degraded set to true and the reason is logged on the span as a "degraded" event, so that
+// degraded successes may be distinguished from failures. This function is a no-op if the context
+// carries no span.
+func MarkDegraded(ctx context.Context, reason string) {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag("degraded", true).LogKV("event", "degraded", "reason", reason)
+	}
+}
+
+// TagExperiment tags the span on the given context with the variant of a feature experiment
+// assigned to the request as experiment.<experiment>=<variant>, so that latency and errors may be
+// compared across variants. This function is a no-op if the context carries no span.
+func TagExperiment(ctx context.Context, experiment, variant string) {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag(fmt.Sprintf("experiment.%s", experiment), variant)
+	}
+}
+
+// TagUser tags the span on the given context with the ID of the authenticated user as user.id, to
+// aid debugging of issues affecting specific users. To avoid recording PII, user IDs which look
+// like email addresses are never tagged, so opaque identifiers should be used. This function is a
+// no-op if the context carries no span.
+func TagUser(ctx context.Context, userID string) {
+	if userID == "" || strings.Contains(userID, "@") {
+		return
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetTag("user.id", userID)
+	}
+}
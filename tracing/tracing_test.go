@@ -16,12 +16,24 @@ package tracing
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/debug"
 	"testing"
+	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spothero/tools/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	jaeger "github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestConfigureTracer(t *testing.T) {
@@ -64,6 +76,35 @@ func TestTraceOutbound(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestTraceOutboundHeaderCasing(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	span := opentracing.StartSpan("test-span")
+	defer span.Finish()
+
+	tests := []struct {
+		name        string
+		opts        []OutboundOption
+		expectedKey string
+	}{
+		{"headers are canonical by default", nil, "Uber-Trace-Id"},
+		{"headers may be explicitly canonical", []OutboundOption{WithHeaderCasing(HeaderCasingCanonical)}, "Uber-Trace-Id"},
+		{"headers may be lowercase", []OutboundOption{WithHeaderCasing(HeaderCasingLowercase)}, "uber-trace-id"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/fake", nil)
+			require.NoError(t, err)
+			require.NoError(t, TraceOutbound(req, span, test.opts...))
+			require.Len(t, req.Header, 1)
+			assert.Contains(t, req.Header, test.expectedKey)
+			assert.Equal(t, span.Context().(jaeger.SpanContext).String(), req.Header[test.expectedKey][0])
+		})
+	}
+}
+
 func TestEmbedCorrelationID(t *testing.T) {
 	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
 	defer closer.Close()
@@ -76,3 +117,492 @@ func TestEmbedCorrelationID(t *testing.T) {
 	assert.NotNil(t, correlationId)
 	assert.NotEqual(t, "", correlationId)
 }
+
+func TestNewTracer(t *testing.T) {
+	globalTracer := &opentracing.NoopTracer{}
+	opentracing.SetGlobalTracer(globalTracer)
+
+	first, err := Config{Enabled: true, ServiceName: "first", AgentHost: "localhost", AgentPort: 6831, DisableGlobalTracer: true}.NewTracer()
+	require.NoError(t, err)
+	defer first.Closer.Close()
+	second, err := Config{Enabled: true, ServiceName: "second", AgentHost: "localhost", AgentPort: 6831, DisableGlobalTracer: true}.NewTracer()
+	require.NoError(t, err)
+	defer second.Closer.Close()
+
+	// Neither tracer replaces the global tracer and both tracers are independent
+	assert.Equal(t, globalTracer, opentracing.GlobalTracer())
+	assert.NotEqual(t, first.Tracer, second.Tracer)
+
+	// Each tracer's middleware creates spans with its own tracer
+	for _, tracer := range []Tracer{first, second} {
+		testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span := opentracing.SpanFromContext(r.Context())
+			require.NotNil(t, span)
+			assert.Equal(t, tracer.Tracer, span.Tracer())
+		})
+		tracer.NewHTTPServerMiddleware()(testHandler).ServeHTTP(
+			httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+	}
+
+	_, err = Config{Enabled: true}.NewTracer()
+	assert.Error(t, err)
+}
+
+func TestTracerEntryPoints(t *testing.T) {
+	// The global tracer is left as a no-op tracer, so every entry point must use the injected tracer
+	reporter := jaeger.NewInMemoryReporter()
+	jaegerTracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	tracer := Tracer{Tracer: jaegerTracer, Closer: closer}
+	ctx := tracer.ContextWithTracer(context.Background())
+
+	t.Run("spans without a parent use the context tracer", func(t *testing.T) {
+		span, _ := StartSpanFromContext(ctx, "test")
+		defer span.Finish()
+		assert.Equal(t, jaegerTracer, span.Tracer())
+	})
+	t.Run("spans with a parent use the parent tracer", func(t *testing.T) {
+		parent := jaegerTracer.StartSpan("parent")
+		defer parent.Finish()
+		span, _ := StartSpanFromContext(opentracing.ContextWithSpan(context.Background(), parent), "test")
+		defer span.Finish()
+		assert.Equal(t, jaegerTracer, span.Tracer())
+	})
+	t.Run("outbound requests are injected with the span tracer", func(t *testing.T) {
+		span := jaegerTracer.StartSpan("test")
+		defer span.Finish()
+		req := httptest.NewRequest("GET", "/", nil)
+		require.NoError(t, TraceOutbound(req, span))
+		assert.NotEmpty(t, req.Header.Get(jaeger.TraceContextHeaderName))
+	})
+	t.Run("serialized contexts are continued by workers", func(t *testing.T) {
+		span, spanCtx := StartSpanFromContext(ctx, "producer")
+		defer span.Finish()
+		token, err := SerializeContext(spanCtx)
+		require.NoError(t, err)
+		workerCtx, finish := WorkerSpan(ctx, token, "test")
+		defer finish()
+		workerSpan := opentracing.SpanFromContext(workerCtx)
+		assert.Equal(t, jaegerTracer, workerSpan.Tracer())
+		assert.Equal(t,
+			span.Context().(jaeger.SpanContext).TraceID(),
+			workerSpan.Context().(jaeger.SpanContext).TraceID())
+	})
+	t.Run("sql spans use the injected tracer", func(t *testing.T) {
+		queryCtx, end, err := tracer.NewSQLMiddleware()(context.Background(), "test", "SELECT 1")
+		require.NoError(t, err)
+		assert.Equal(t, jaegerTracer, opentracing.SpanFromContext(queryCtx).Tracer())
+		_, err = end(queryCtx, "test", "SELECT 1", nil)
+		assert.NoError(t, err)
+	})
+	t.Run("client requests use the injected tracer", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer backend.Close()
+		reported := reporter.SpansSubmitted()
+		resp, err := tracer.NewHTTPClient(nil).Get(backend.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, reported+1, reporter.SpansSubmitted())
+	})
+	t.Run("proxied requests use the injected tracer", func(t *testing.T) {
+		var backendErr error
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, backendErr = jaegerTracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(r.Header))
+		}))
+		defer backend.Close()
+		req, err := http.NewRequest("GET", backend.URL, nil)
+		require.NoError(t, err)
+		resp, err := tracer.NewProxyTransport(nil).RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.NoError(t, backendErr)
+	})
+}
+
+func TestIsSampled(t *testing.T) {
+	tests := []struct {
+		name          string
+		tracer        func() (opentracing.Tracer, io.Closer)
+		withSpan      bool
+		expectSampled bool
+	}{
+		{
+			"sampled spans are reported as sampled",
+			func() (opentracing.Tracer, io.Closer) {
+				return jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+			},
+			true,
+			true,
+		},
+		{
+			"unsampled spans are reported as unsampled",
+			func() (opentracing.Tracer, io.Closer) {
+				return jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+			},
+			true,
+			false,
+		},
+		{
+			"contexts without a span are reported as unsampled",
+			func() (opentracing.Tracer, io.Closer) {
+				return jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+			},
+			false,
+			false,
+		},
+		{
+			"non-jaeger spans are reported as unsampled",
+			func() (opentracing.Tracer, io.Closer) {
+				return opentracing.NoopTracer{}, ioutil.NopCloser(nil)
+			},
+			true,
+			false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer, closer := test.tracer()
+			defer closer.Close()
+			ctx := context.Background()
+			if test.withSpan {
+				span := tracer.StartSpan("test")
+				defer span.Finish()
+				ctx = opentracing.ContextWithSpan(ctx, span)
+			}
+			assert.Equal(t, test.expectSampled, IsSampled(ctx))
+		})
+	}
+}
+
+func TestTracedLogger(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	core, recordedLogs := observer.New(zapcore.InfoLevel)
+	ctx := log.NewContext(context.Background(), zap.New(core))
+
+	// Logs without an active span are not enriched
+	TracedLogger(ctx).Info("no span")
+
+	span, spanCtx := opentracing.StartSpanFromContextWithTracer(ctx, tracer, "test")
+	defer span.Finish()
+	TracedLogger(spanCtx).Info("with span")
+
+	logs := recordedLogs.All()
+	require.Len(t, logs, 2)
+	assert.NotContains(t, logs[0].ContextMap(), "trace_id")
+	jaegerSpanCtx, ok := span.Context().(jaeger.SpanContext)
+	require.True(t, ok)
+	assert.Equal(t, jaegerSpanCtx.TraceID().String(), logs[1].ContextMap()["trace_id"])
+	assert.Equal(t, jaegerSpanCtx.SpanID().String(), logs[1].ContextMap()["span_id"])
+}
+
+func TestMarkDegraded(t *testing.T) {
+	tracer := mocktracer.New()
+	// Contexts without spans are ignored
+	MarkDegraded(context.Background(), "stale cache")
+
+	span, ctx := opentracing.StartSpanFromContextWithTracer(context.Background(), tracer, "test")
+	MarkDegraded(ctx, "stale cache")
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, true, spans[0].Tag("degraded"))
+	assert.Nil(t, spans[0].Tag("error"))
+	require.Len(t, spans[0].Logs(), 1)
+	fields := spans[0].Logs()[0].Fields
+	require.Len(t, fields, 2)
+	assert.Equal(t, "degraded", fields[0].ValueString)
+	assert.Equal(t, "reason", fields[1].Key)
+	assert.Equal(t, "stale cache", fields[1].ValueString)
+}
+
+func TestSpanLogFields(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+
+	assert.Empty(t, SpanLogFields(context.Background()))
+	_, mockCtx := opentracing.StartSpanFromContextWithTracer(context.Background(), mocktracer.New(), "test")
+	assert.Empty(t, SpanLogFields(mockCtx))
+
+	span, ctx := opentracing.StartSpanFromContextWithTracer(context.Background(), tracer, "test")
+	defer span.Finish()
+	sc := span.Context().(jaeger.SpanContext)
+	assert.Equal(t, []zap.Field{
+		zap.String("trace_id", sc.TraceID().String()),
+		zap.String("span_id", sc.SpanID().String()),
+		zap.Bool("sampled", true),
+	}, SpanLogFields(ctx))
+}
+
+func TestStartSpanLogged(t *testing.T) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewInMemoryReporter())
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	core, recordedLogs := observer.New(zapcore.InfoLevel)
+	ctx := log.NewContext(context.Background(), zap.New(core))
+
+	span, spanCtx := StartSpanLogged(ctx, "work", zap.String("job", "cleanup"))
+	defer span.Finish()
+	assert.Equal(t, span, opentracing.SpanFromContext(spanCtx))
+	log.Get(spanCtx).Info("working")
+
+	logs := recordedLogs.All()
+	require.Len(t, logs, 1)
+	jaegerSpanCtx, ok := span.Context().(jaeger.SpanContext)
+	require.True(t, ok)
+	assert.Equal(t, jaegerSpanCtx.TraceID().String(), logs[0].ContextMap()["trace_id"])
+	assert.Equal(t, jaegerSpanCtx.SpanID().String(), logs[0].ContextMap()["span_id"])
+	assert.Equal(t, "cleanup", logs[0].ContextMap()["job"])
+}
+
+func TestSuppress(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	ctx := context.Background()
+	assert.False(t, IsSuppressed(ctx))
+	suppressedCtx := Suppress(ctx)
+	assert.True(t, IsSuppressed(suppressedCtx))
+
+	// Spans started directly, by the HTTP and SQL middleware, and for jobs, are no-ops
+	span, _ := StartSpanFromContext(suppressedCtx, "suppressed")
+	span.Finish()
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sqlCtx, mwEnd, err := SQLMiddleware(r.Context(), "query", "SELECT 1")
+		require.NoError(t, err)
+		_, err = mwEnd(sqlCtx, "query", "SELECT 1", nil)
+		require.NoError(t, err)
+	})
+	HTTPServerMiddleware(testHandler).ServeHTTP(
+		httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil).WithContext(suppressedCtx))
+	_, finishJob := StartJobTrace(suppressedCtx, "job")
+	finishJob()
+	_, finishWorker := WorkerSpan(suppressedCtx, "", "job")
+	finishWorker()
+	assert.Len(t, tracer.FinishedSpans(), 0)
+
+	// Spans on unsuppressed contexts are still recorded
+	span, _ = StartSpanFromContext(ctx, "not-suppressed")
+	span.Finish()
+	assert.Len(t, tracer.FinishedSpans(), 1)
+}
+
+func TestJaegerConfigurationBuildInfoTags(t *testing.T) {
+	assert.Empty(t, Config{ServiceName: "test"}.jaegerConfiguration().Tags)
+
+	assert.Empty(t, Config{ServiceName: "test", BuildRevision: "abc123"}.jaegerConfiguration().Tags)
+
+	tags := Config{ServiceName: "test", BuildInfoTags: true, BuildRevision: "abc123"}.jaegerConfiguration().Tags
+	require.NotEmpty(t, tags)
+	assert.Equal(t, opentracing.Tag{Key: "runtime.version", Value: runtime.Version()}, tags[0])
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		require.Len(t, tags, 3)
+		assert.Equal(t, opentracing.Tag{Key: "build.version", Value: info.Main.Version}, tags[1])
+	} else {
+		assert.Len(t, tags, 2)
+	}
+	assert.Equal(t, opentracing.Tag{Key: "build.revision", Value: "abc123"}, tags[len(tags)-1])
+
+	// Builds without a revision omit the build.revision tag
+	for _, tag := range (Config{ServiceName: "test", BuildInfoTags: true}.jaegerConfiguration().Tags) {
+		assert.NotEqual(t, "build.revision", tag.Key)
+	}
+
+	// The tags are reported as process tags of the tracer
+	tracer, err := Config{Enabled: true, ServiceName: "test", BuildInfoTags: true, BuildRevision: "abc123", DisableGlobalTracer: true}.NewTracer()
+	require.NoError(t, err)
+	defer tracer.Closer.Close()
+	span := tracer.Tracer.StartSpan("test")
+	defer span.Finish()
+	processTags := make(map[string]interface{})
+	for _, tag := range jaeger.BuildJaegerProcessThrift(span.(*jaeger.Span)).Tags {
+		processTags[tag.Key] = *tag.VStr
+	}
+	assert.Equal(t, runtime.Version(), processTags["runtime.version"])
+	assert.Equal(t, "abc123", processTags["build.revision"])
+}
+
+func TestJaegerConfigurationBaggageRestrictions(t *testing.T) {
+	jaegerConfig := Config{ServiceName: "test"}.jaegerConfiguration()
+	assert.Nil(t, jaegerConfig.BaggageRestrictions)
+
+	jaegerConfig = Config{
+		ServiceName:                        "test",
+		BaggageRestrictionsEnabled:         true,
+		BaggageRestrictionsHostPort:        "agent:5778",
+		BaggageRestrictionsRefreshInterval: time.Minute,
+		BaggageRestrictionsDenyOnFailure:   true,
+	}.jaegerConfiguration()
+	require.NotNil(t, jaegerConfig.BaggageRestrictions)
+	assert.Equal(t, "agent:5778", jaegerConfig.BaggageRestrictions.HostPort)
+	assert.Equal(t, time.Minute, jaegerConfig.BaggageRestrictions.RefreshInterval)
+	assert.True(t, jaegerConfig.BaggageRestrictions.DenyBaggageOnInitializationFailure)
+
+	// The restriction manager is initialized with the tracer when enabled
+	tracer, err := Config{
+		Enabled:                    true,
+		ServiceName:                "test",
+		AgentHost:                  "localhost",
+		AgentPort:                  6831,
+		BaggageRestrictionsEnabled: true,
+		DisableGlobalTracer:        true,
+	}.NewTracer()
+	require.NoError(t, err)
+	assert.NoError(t, tracer.Closer.Close())
+}
+
+func TestStartRenderSpan(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	span, ctx := StartRenderSpan(context.Background(), "index.html")
+	assert.Equal(t, span, opentracing.SpanFromContext(ctx))
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "template_index.html", spans[0].OperationName)
+	assert.Equal(t, "template", spans[0].Tag("component"))
+	assert.Equal(t, "index.html", spans[0].Tag("template.name"))
+}
+
+func TestStartSpanIfSampled(t *testing.T) {
+	tests := []struct {
+		name          string
+		sampled       bool
+		expectSampled bool
+	}{
+		{"spans are started under sampled parents", true, true},
+		{"no-op spans are returned under unsampled parents", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reporter := jaeger.NewInMemoryReporter()
+			tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(test.sampled), reporter)
+			defer closer.Close()
+			opentracing.SetGlobalTracer(tracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			parent, ctx := opentracing.StartSpanFromContext(context.Background(), "parent")
+			span, spanCtx, sampled := StartSpanIfSampled(ctx, "child")
+			assert.Equal(t, test.expectSampled, sampled)
+			if test.expectSampled {
+				assert.Equal(t, span, opentracing.SpanFromContext(spanCtx))
+			} else {
+				assert.Equal(t, opentracing.NoopTracer{}.StartSpan("child"), span)
+				assert.Equal(t, ctx, spanCtx)
+			}
+			span.Finish()
+			parent.Finish()
+			if test.expectSampled {
+				assert.Equal(t, 2, reporter.SpansSubmitted())
+			} else {
+				assert.Equal(t, 0, reporter.SpansSubmitted())
+			}
+		})
+	}
+}
+
+func BenchmarkStartSpanIfSampled(b *testing.B) {
+	tracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(false), jaeger.NewNullReporter())
+	defer closer.Close()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	parent, ctx := opentracing.StartSpanFromContext(context.Background(), "parent")
+	defer parent.Finish()
+
+	b.Run("StartSpanIfSampled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			span, _, _ := StartSpanIfSampled(ctx, "child")
+			span.Finish()
+		}
+	})
+	b.Run("StartSpanFromContext", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			span, _ := StartSpanFromContext(ctx, "child")
+			span.Finish()
+		}
+	})
+}
+
+func TestJaegerConfigurationVersionTag(t *testing.T) {
+	configured, err := Config{
+		Enabled:             true,
+		ServiceName:         "test",
+		AgentHost:           "localhost",
+		AgentPort:           6831,
+		DisableGlobalTracer: true,
+		Version:             "1.2.3",
+	}.NewTracer()
+	require.NoError(t, err)
+	defer configured.Closer.Close()
+	assert.Equal(t, "1.2.3", configured.Version)
+
+	reporter := jaeger.NewInMemoryReporter()
+	jaegerTracer, closer := jaeger.NewTracer("t", jaeger.NewConstSampler(true), reporter)
+	defer closer.Close()
+	tracer := Tracer{Tracer: jaegerTracer, Closer: closer, Version: configured.Version}
+	sqlMiddleware := tracer.NewSQLMiddleware()
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, mwEnd, err := sqlMiddleware(r.Context(), "query", "SELECT 1")
+		require.NoError(t, err)
+		_, err = mwEnd(ctx, "query", "SELECT 1", nil)
+		require.NoError(t, err)
+	})
+	tracer.NewHTTPServerMiddleware()(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/path", nil))
+
+	// Both the request span and the SQL span are tagged with the version
+	spans := reporter.GetSpans()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, []string{"1.2.3"}, jaegerTagValues(span, "version"))
+	}
+}
+
+func TestIsSpanVerbose(t *testing.T) {
+	assert.False(t, IsSpanVerbose(context.Background()))
+	assert.True(t, IsSpanVerbose(WithSpanVerbose(context.Background())))
+}
+
+func TestTagExperiment(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("test")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+	TagExperiment(ctx, "checkout_flow", "treatment")
+	TagExperiment(ctx, "pricing", "control")
+	span.Finish()
+
+	spans := tracer.FinishedSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "treatment", spans[0].Tag("experiment.checkout_flow"))
+	assert.Equal(t, "control", spans[0].Tag("experiment.pricing"))
+	// Contexts without spans are ignored
+	TagExperiment(context.Background(), "checkout_flow", "treatment")
+}
+
+func TestTagUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		userID       string
+		expectedUser interface{}
+	}{
+		{"user ids are tagged", "12345", "12345"},
+		{"empty user ids are not tagged", "", nil},
+		{"email addresses are not tagged", "user@example.com", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracer := mocktracer.New()
+			span := tracer.StartSpan("test")
+			TagUser(opentracing.ContextWithSpan(context.Background(), span), test.userID)
+			span.Finish()
+			assert.Equal(t, test.expectedUser, tracer.FinishedSpans()[0].Tag("user.id"))
+		})
+	}
+	// Contexts without spans are ignored
+	TagUser(context.Background(), "12345")
+}
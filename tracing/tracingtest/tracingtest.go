@@ -0,0 +1,146 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracingtest provides helpers for asserting on the spans produced in tests. It is kept
+// separate from the tracing package so that testing dependencies are not pulled into production
+// binaries.
+package tracingtest
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/uber/jaeger-client-go"
+	j "github.com/uber/jaeger-client-go/thrift-gen/jaeger"
+)
+
+// NewTracer returns a jaeger Tracer which samples every span, along with the in-memory reporter
+// which receives every finished span. The tracer is closed when the test completes.
+func NewTracer(t testing.TB) (opentracing.Tracer, *jaeger.InMemoryReporter) {
+	reporter := jaeger.NewInMemoryReporter()
+	tracer, closer := jaeger.NewTracer("tracingtest", jaeger.NewConstSampler(true), reporter)
+	t.Cleanup(func() {
+		_ = closer.Close()
+	})
+	return tracer, reporter
+}
+
+// NewGlobalTracer returns a tracer and reporter as NewTracer does, and installs the tracer as the
+// OpenTracing global tracer until the test completes. Spans started from a context, such as those
+// of tracing.SQLMiddleware, use the global tracer, so it must be installed for those spans to be
+// reported and nested under spans of the tracer.
+func NewGlobalTracer(t testing.TB) (opentracing.Tracer, *jaeger.InMemoryReporter) {
+	tracer, reporter := NewTracer(t)
+	previous := opentracing.GlobalTracer()
+	opentracing.SetGlobalTracer(tracer)
+	t.Cleanup(func() {
+		opentracing.SetGlobalTracer(previous)
+	})
+	return tracer, reporter
+}
+
+// Tag returns the value of the given tag on the span, and whether the tag was set. Both jaeger and
+// mocktracer spans are supported. Tag values of jaeger spans are returned as reported, so integer
+// values are returned as int64 and floating point values as float64.
+func Tag(span opentracing.Span, key string) (interface{}, bool) {
+	switch s := span.(type) {
+	case *mocktracer.MockSpan:
+		value, ok := s.Tags()[key]
+		return value, ok
+	case *jaeger.Span:
+		return thriftTag(jaeger.BuildJaegerThrift(s).Tags, key)
+	}
+	return nil, false
+}
+
+// thriftTag returns the value of the given tag in the list of reported jaeger tags
+func thriftTag(tags []*j.Tag, key string) (interface{}, bool) {
+	for _, tag := range tags {
+		if tag.Key != key {
+			continue
+		}
+		switch tag.VType {
+		case j.TagType_STRING:
+			return tag.GetVStr(), true
+		case j.TagType_BOOL:
+			return tag.GetVBool(), true
+		case j.TagType_LONG:
+			return tag.GetVLong(), true
+		case j.TagType_DOUBLE:
+			return tag.GetVDouble(), true
+		case j.TagType_BINARY:
+			return tag.GetVBinary(), true
+		}
+	}
+	return nil, false
+}
+
+// reportedValue returns the given value as it would be reported on a jaeger span, so that
+// expected values may be compared with the values of reported tags regardless of their Go type
+func reportedValue(value interface{}) interface{} {
+	tracer, closer := jaeger.NewTracer("tracingtest", jaeger.NewConstSampler(false), jaeger.NewNullReporter())
+	defer closer.Close()
+	span := tracer.StartSpan("", opentracing.Tag{Key: "value", Value: value})
+	reported, _ := thriftTag(jaeger.BuildJaegerThrift(span.(*jaeger.Span)).Tags, "value")
+	return reported
+}
+
+// AssertTag asserts that the span has the given tag set to the expected value. For jaeger spans,
+// the expected value is compared as it would be reported, so that, for example, an expected int
+// matches a tag set as an int64.
+func AssertTag(t testing.TB, span opentracing.Span, key string, expected interface{}) bool {
+	t.Helper()
+	actual, ok := Tag(span, key)
+	if !ok {
+		return assert.Fail(t, "span tag not found", "span does not have the tag %q", key)
+	}
+	if _, isJaeger := span.(*jaeger.Span); isJaeger {
+		expected = reportedValue(expected)
+	}
+	return assert.Equal(t, expected, actual, "unexpected value for span tag %q", key)
+}
+
+// AssertSpanName asserts that the span has the given operation name
+func AssertSpanName(t testing.TB, span opentracing.Span, name string) bool {
+	t.Helper()
+	switch s := span.(type) {
+	case *mocktracer.MockSpan:
+		return assert.Equal(t, name, s.OperationName, "unexpected span name")
+	case *jaeger.Span:
+		return assert.Equal(t, name, s.OperationName(), "unexpected span name")
+	}
+	return assert.Fail(t, "unsupported span type", "span of type %T is not supported", span)
+}
+
+// AssertChildOf asserts that the child span was started as a direct child of the parent span,
+// within the same trace. Both jaeger and mocktracer spans are supported.
+func AssertChildOf(t testing.TB, child, parent opentracing.Span) bool {
+	t.Helper()
+	switch c := child.(type) {
+	case *mocktracer.MockSpan:
+		if p, ok := parent.(*mocktracer.MockSpan); ok {
+			return assert.Equal(t, p.SpanContext.TraceID, c.SpanContext.TraceID, "spans belong to different traces") &&
+				assert.Equal(t, p.SpanContext.SpanID, c.ParentID, "span is not a child of the parent span")
+		}
+	case *jaeger.Span:
+		if p, ok := parent.(*jaeger.Span); ok {
+			childCtx, parentCtx := c.Context().(jaeger.SpanContext), p.Context().(jaeger.SpanContext)
+			return assert.Equal(t, parentCtx.TraceID(), childCtx.TraceID(), "spans belong to different traces") &&
+				assert.Equal(t, parentCtx.SpanID(), childCtx.ParentID(), "span is not a child of the parent span")
+		}
+	}
+	return assert.Fail(t, "unsupported span types", "spans of type %T and %T are not supported", child, parent)
+}
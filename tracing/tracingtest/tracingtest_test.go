@@ -0,0 +1,103 @@
+// Copyright 2020 SpotHero
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracingtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/spothero/tools/http/writer"
+	"github.com/spothero/tools/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertHelpers(t *testing.T) {
+	tracer, reporter := NewTracer(t)
+
+	router := mux.NewRouter()
+	router.Use(writer.StatusRecorderMiddleware, tracing.NewHTTPServerMiddleware(tracing.WithTracer(tracer)))
+	router.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/123", nil))
+
+	spans := reporter.GetSpans()
+	require.Len(t, spans, 1)
+	AssertSpanName(t, spans[0], "/users/{id}")
+	AssertTag(t, spans[0], "http.method", "GET")
+	AssertTag(t, spans[0], "http.status_code", "404")
+
+	// Failed assertions are reported to the given test
+	mockT := &testing.T{}
+	assert.False(t, AssertTag(mockT, spans[0], "http.method", "POST"))
+	assert.False(t, AssertTag(mockT, spans[0], "missing", "value"))
+	assert.False(t, AssertSpanName(mockT, spans[0], "/orders"))
+}
+
+func TestTag(t *testing.T) {
+	tracer, reporter := NewTracer(t)
+	span := tracer.StartSpan("test")
+	span.SetTag("count", 3).SetTag("ratio", float32(0.5)).SetTag("error", true)
+	span.Finish()
+
+	spans := reporter.GetSpans()
+	require.Len(t, spans, 1)
+	value, ok := Tag(spans[0], "count")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), value)
+	AssertTag(t, spans[0], "count", 3)
+	AssertTag(t, spans[0], "ratio", 0.5)
+	AssertTag(t, spans[0], "error", true)
+
+	mockSpan := mocktracer.New().StartSpan("mock")
+	mockSpan.SetTag("count", 3)
+	AssertTag(t, mockSpan, "count", 3)
+	AssertSpanName(t, mockSpan, "mock")
+	_, ok = Tag(mockSpan, "missing")
+	assert.False(t, ok)
+}
+
+// TestSQLMiddlewareNesting guards the contract that SQL spans started with the request context
+// are children of the HTTP server span of the request
+func TestSQLMiddlewareNesting(t *testing.T) {
+	tracer, reporter := NewGlobalTracer(t)
+
+	handler := tracing.NewHTTPServerMiddleware(tracing.WithTracer(tracer))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, mwEnd, err := tracing.SQLMiddleware(r.Context(), "get_user", "SELECT * FROM users WHERE id = $1", 123)
+			require.NoError(t, err)
+			_, err = mwEnd(ctx, "get_user", "SELECT * FROM users WHERE id = $1", nil, 123)
+			require.NoError(t, err)
+		}))
+	writer.StatusRecorderMiddleware(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/123", nil))
+
+	// The SQL span finishes, and is reported, before the HTTP span
+	spans := reporter.GetSpans()
+	require.Len(t, spans, 2)
+	AssertSpanName(t, spans[0], "db_get_user")
+	AssertChildOf(t, spans[0], spans[1])
+
+	// Spans outside of the request are not children of the HTTP span
+	sqlSpan, _ := tracing.StartSpanFromContext(context.Background(), "db_unrelated")
+	sqlSpan.Finish()
+	mockT := &testing.T{}
+	assert.False(t, AssertChildOf(mockT, sqlSpan, spans[1]))
+	assert.False(t, AssertChildOf(mockT, mocktracer.New().StartSpan("mock"), spans[1]))
+}